@@ -0,0 +1,139 @@
+package lrmp
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+/*
+ * the overflow policies of a ChannelHandler.
+ */
+const (
+	/*
+	 * wait for the consumer. The handler is called by the goroutine which
+	 * read a packet or ran a timer, it stalls until the consumer catches up
+	 * and the upcalls of the other goroutines wait behind.
+	 */
+	OverflowBlock = 1
+	/* discard the data or event which does not fit */
+	OverflowDropNewest = 2
+	/* discard the oldest data or event queued */
+	OverflowDropOldest = 3
+)
+
+/**
+ * ChannelHandler is an EventHandler which queues the data and events to
+ * bounded channels, so that the application consumes them in its own
+ * goroutines without holding up the session. When a channel is full the
+ * overflow policy applies.
+ */
+type ChannelHandler struct {
+	sync.Mutex
+	data    chan *Packet
+	events  chan Event
+	policy  int
+	dropped int
+}
+
+/**
+ * creates a handler with channels of the given capacity, at least 1, and one
+ * of the overflow policies.
+ */
+func NewChannelHandler(size int, policy int) (*ChannelHandler, error) {
+	if size < 1 {
+		return nil, errors.New("invalid channel size " + strconv.Itoa(size))
+	}
+	if policy != OverflowBlock && policy != OverflowDropNewest && policy != OverflowDropOldest {
+		return nil, errors.New("unknown overflow policy " + strconv.Itoa(policy))
+	}
+
+	c := ChannelHandler{data: make(chan *Packet, size), events: make(chan Event, size), policy: policy}
+	return &c, nil
+}
+
+/**
+ * returns the channel of received packets.
+ */
+func (c *ChannelHandler) Data() <-chan *Packet {
+	return c.data
+}
+
+/**
+ * returns the channel of events.
+ */
+func (c *ChannelHandler) Events() <-chan Event {
+	return c.events
+}
+
+/**
+ * returns the number of packets and events discarded on overflow.
+ */
+func (c *ChannelHandler) Dropped() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.dropped
+}
+
+func (c *ChannelHandler) drop() {
+	c.Lock()
+	c.dropped++
+	c.Unlock()
+}
+
+func (c *ChannelHandler) ProcessData(p *Packet) {
+	if c.policy == OverflowBlock {
+		c.data <- p
+		return
+	}
+
+	for {
+		select {
+		case c.data <- p:
+			return
+		default:
+		}
+
+		if c.policy == OverflowDropNewest {
+			c.drop()
+			return
+		}
+
+		select {
+		case <-c.data:
+			c.drop()
+		default:
+		}
+	}
+}
+
+func (c *ChannelHandler) ProcessEvent(event int, data interface{}) {
+	ev, ok := data.(Event)
+	if !ok {
+		return
+	}
+
+	if c.policy == OverflowBlock {
+		c.events <- ev
+		return
+	}
+
+	for {
+		select {
+		case c.events <- ev:
+			return
+		default:
+		}
+
+		if c.policy == OverflowDropNewest {
+			c.drop()
+			return
+		}
+
+		select {
+		case <-c.events:
+			c.drop()
+		default:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package lrmp_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+func TestChannelHandlerPolicies(t *testing.T) {
+	if _, err := lrmp.NewChannelHandler(1, 0); err == nil {
+		t.Fatal("unknown overflow policy accepted")
+	}
+	for _, policy := range []int{lrmp.OverflowBlock, lrmp.OverflowDropNewest, lrmp.OverflowDropOldest} {
+		for _, size := range []int{-1, 0} {
+			if _, err := lrmp.NewChannelHandler(size, policy); err == nil {
+				t.Fatalf("size %d accepted with policy %d", size, policy)
+			}
+		}
+	}
+
+	for _, policy := range []int{lrmp.OverflowDropNewest, lrmp.OverflowDropOldest} {
+		n := newTestNet(t, vnet.LinkConfig{})
+
+		a := n.join("10.0.0.1", n.profile())
+
+		c, err := lrmp.NewChannelHandler(2, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := n.profile()
+		p.Handler = c
+		n.join("10.0.0.2", p)
+
+		sendPackets(t, a, 0, 10)
+		n.runUntil(10*time.Second, func() bool { return c.Dropped() >= 8 })
+
+		/* the joined and sender events fill the events channel */
+
+		want := []string{"0", "1"}
+		if policy == lrmp.OverflowDropOldest {
+			want = []string{"8", "9"}
+		}
+
+		for _, w := range want {
+			p := <-c.Data()
+			if d := string(p.GetDataBuffer()[:p.GetDataLength()]); d != w {
+				t.Fatalf("policy %d: received %q, want %q", policy, d, w)
+			}
+		}
+		if c.Dropped() != 8 {
+			t.Fatalf("policy %d: dropped %d", policy, c.Dropped())
+		}
+	}
+}
+
+/* the reception waits for the consumer */
+func TestChannelHandlerBlocks(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", n.profile())
+
+	c, err := lrmp.NewChannelHandler(1, lrmp.OverflowBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := n.profile()
+	p.Handler = c
+	n.join("10.0.0.2", p)
+
+	go func() {
+		for range c.Events() {
+		}
+	}()
+
+	sendPackets(t, a, 0, 100)
+
+	for i := 0; i < 100; i++ {
+		var p *lrmp.Packet
+
+		n.runUntil(10*time.Second, func() bool {
+			select {
+			case p = <-c.Data():
+				return true
+			default:
+				return false
+			}
+		})
+
+		if p == nil {
+			t.Fatalf("packet %d not received", i)
+		}
+		if d := string(p.GetDataBuffer()[:p.GetDataLength()]); d != strconv.Itoa(i) {
+			t.Fatalf("received %q, want %d", d, i)
+		}
+	}
+	if c.Dropped() != 0 {
+		t.Fatalf("dropped %d", c.Dropped())
+	}
+}
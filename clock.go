@@ -0,0 +1,177 @@
+package lrmp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+/**
+ * Clock is the source of time used by a session for timers, flow control
+ * and loss recovery. The default is the system clock, a VirtualClock can be
+ * set in the Profile to drive protocol scenarios deterministically.
+ */
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	/*
+	 * calls f once d has elapsed, unless the returned Timer is stopped
+	 * before.
+	 */
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+/**
+ * Timer is a pending call scheduled by Clock.AfterFunc. Stop returns false
+ * if the call has already been made or the timer was already stopped.
+ */
+type Timer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                            { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                     { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time    { return time.After(d) }
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+/**
+ * the real time clock.
+ */
+var SystemClock Clock = systemClock{}
+
+/**
+ * returns a channel receiving the time once d has elapsed, like After, and
+ * the Timer to stop when the channel is no longer waited on.
+ */
+func newTimer(clock Clock, d time.Duration) (<-chan time.Time, Timer) {
+	c := make(chan time.Time, 1)
+
+	t := clock.AfterFunc(d, func() { c <- clock.Now() })
+
+	return c, t
+}
+
+type clockWaiter struct {
+	clock    *VirtualClock
+	deadline time.Time
+	f        func()
+}
+
+func (w *clockWaiter) Stop() bool {
+	c := w.clock
+
+	c.Lock()
+	defer c.Unlock()
+
+	for i, other := range c.waiters {
+		if other == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * VirtualClock is a Clock that only moves when advanced explicitly. Sleep and
+ * After block until Advance moves the clock past their deadline.
+ *
+ * Advance is synchronous: the functions scheduled with AfterFunc, which
+ * include the protocol timers of a session, are called by Advance itself in
+ * deadline order, in scheduling order for equal deadlines, and have returned
+ * when it returns. They must not call Advance.
+ */
+type VirtualClock struct {
+	sync.Mutex
+	now       time.Time
+	waiters   []*clockWaiter
+	advancing sync.Mutex
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	c := VirtualClock{now: start}
+	return &c
+}
+
+func (c *VirtualClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.Lock()
+	defer c.Unlock()
+
+	w := clockWaiter{clock: c, deadline: c.now.Add(d), f: f}
+
+	/* keep the waiters sorted, after those with the same deadline */
+
+	i := sort.Search(len(c.waiters), func(i int) bool { return c.waiters[i].deadline.After(w.deadline) })
+
+	c.waiters = append(c.waiters, nil)
+	copy(c.waiters[i+1:], c.waiters[i:])
+	c.waiters[i] = &w
+
+	return &w
+}
+
+func (c *VirtualClock) After(d time.Duration) <-chan time.Time {
+	if d <= 0 {
+		ch := make(chan time.Time, 1)
+		ch <- c.Now()
+		return ch
+	}
+	ch, _ := newTimer(c, d)
+	return ch
+}
+
+func (c *VirtualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+/**
+ * moves the clock forward by d, running every function and waking up every
+ * sleeper whose deadline has been reached in deadline order. The clock is
+ * set to each deadline in turn, so that the functions see the time they
+ * were scheduled for. A function scheduled with a non positive duration is
+ * called by the next Advance.
+ */
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.advancing.Lock()
+	defer c.advancing.Unlock()
+
+	c.Lock()
+
+	target := c.now.Add(d)
+
+	for len(c.waiters) > 0 && !c.waiters[0].deadline.After(target) {
+		w := c.waiters[0]
+		c.waiters = c.waiters[1:]
+
+		if w.deadline.After(c.now) {
+			c.now = w.deadline
+		}
+
+		c.Unlock()
+		w.f()
+		c.Lock()
+	}
+
+	c.now = target
+
+	c.Unlock()
+}
+
+/**
+ * returns the number of functions and sleepers waiting for the clock to
+ * advance.
+ */
+func (c *VirtualClock) Pending() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.waiters)
+}
@@ -0,0 +1,182 @@
+package lrmp
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestVirtualClockRunsFunctionsInOrder(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	var calls []string
+	var times []time.Duration
+
+	record := func(name string) func() {
+		return func() {
+			calls = append(calls, name)
+			times = append(times, c.Now().Sub(epoch))
+		}
+	}
+
+	c.AfterFunc(30*time.Millisecond, record("c"))
+	c.AfterFunc(10*time.Millisecond, record("a"))
+	c.AfterFunc(10*time.Millisecond, record("b"))
+
+	c.Advance(20 * time.Millisecond)
+
+	if len(calls) != 2 || calls[0] != "a" || calls[1] != "b" {
+		t.Fatalf("calls %v, want [a b]", calls)
+	}
+	if times[0] != 10*time.Millisecond || times[1] != 10*time.Millisecond {
+		t.Fatalf("called at %v, want 10ms", times)
+	}
+	if now := c.Now().Sub(epoch); now != 20*time.Millisecond {
+		t.Fatalf("now %v, want 20ms", now)
+	}
+
+	c.Advance(10 * time.Millisecond)
+
+	if len(calls) != 3 || calls[2] != "c" {
+		t.Fatalf("calls %v, want [a b c]", calls)
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("%d pending", c.Pending())
+	}
+}
+
+func TestVirtualClockRunsFunctionsScheduledWhileAdvancing(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	count := 0
+
+	var tick func()
+	tick = func() {
+		count++
+		c.AfterFunc(10*time.Millisecond, tick)
+	}
+	c.AfterFunc(10*time.Millisecond, tick)
+
+	c.Advance(100 * time.Millisecond)
+
+	if count != 10 {
+		t.Fatalf("ticked %d times, want 10", count)
+	}
+	if c.Pending() != 1 {
+		t.Fatalf("%d pending, want 1", c.Pending())
+	}
+}
+
+func TestVirtualClockStop(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	called := false
+	timer := c.AfterFunc(10*time.Millisecond, func() { called = true })
+
+	if !timer.Stop() {
+		t.Fatal("Stop of a pending timer returned false")
+	}
+	if timer.Stop() {
+		t.Fatal("second Stop returned true")
+	}
+	if c.Pending() != 0 {
+		t.Fatalf("%d pending after Stop", c.Pending())
+	}
+
+	c.Advance(time.Second)
+
+	if called {
+		t.Fatal("stopped timer called")
+	}
+}
+
+func TestVirtualClockAfterAndSleep(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	select {
+	case <-c.After(0):
+	default:
+		t.Fatal("After(0) not ready")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Sleep(time.Second)
+		close(done)
+	}()
+
+	for c.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Advance(time.Second)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("sleeper not woken up")
+	}
+}
+
+type countingHandler struct {
+	data []interface{}
+}
+
+func (h *countingHandler) handleTimerTask(data interface{}, thetime time.Time) {
+	h.data = append(h.data, data)
+}
+
+func TestTimerManagerRecallAndStop(t *testing.T) {
+	c := NewVirtualClock(epoch)
+	tm := newTimerManager(c)
+	h := countingHandler{}
+
+	recalled := tm.registerTimer(100, &h, "recalled")
+	tm.registerTimer(200, &h, "fired")
+	tm.recallTimer(recalled)
+
+	c.Advance(time.Second)
+
+	if len(h.data) != 1 || h.data[0] != "fired" {
+		t.Fatalf("handled %v, want [fired]", h.data)
+	}
+
+	tm.registerTimer(100, &h, "stopped")
+	tm.stop()
+	tm.registerTimer(100, &h, "after stop")
+
+	if c.Pending() != 0 {
+		t.Fatalf("%d timers pending after stop", c.Pending())
+	}
+
+	c.Advance(time.Second)
+
+	if len(h.data) != 1 {
+		t.Fatalf("handled %v after stop", h.data)
+	}
+}
+
+func TestDomainReenabledAfterMaxDisableTime(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	top := newDomain(63, c)
+	low := newDomain(15, c)
+	top.setChild(low)
+
+	low.disable()
+
+	c.Advance(MaxDisableTime * time.Millisecond)
+	low.checkState()
+
+	if low.isEnabled() {
+		t.Fatal("enabled before MaxDisableTime")
+	}
+
+	c.Advance(time.Millisecond)
+	low.checkState()
+
+	if !low.isEnabled() {
+		t.Fatal("still disabled after MaxDisableTime")
+	}
+}
@@ -0,0 +1,252 @@
+package lrmp_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/**
+ * closes the session in the background, the returned function reports if
+ * Close has returned and its error.
+ */
+func closeAsync(ctx context.Context, l *lrmp.Lrmp) func() (bool, error) {
+	done := make(chan error, 1)
+
+	go func() { done <- l.Close(ctx) }()
+
+	var closed bool
+	var err error
+
+	return func() (bool, error) {
+		if !closed {
+			select {
+			case err = <-done:
+				closed = true
+			default:
+			}
+		}
+		return closed, err
+	}
+}
+
+/*
+ * the receivers remove the sender once when it says BYE, the repairs and
+ * reports it sends during the linger time and the repeated BYE are ignored.
+ */
+func TestByeRemovesSenderOnce(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Loss: 0.1, Delay: 5 * time.Millisecond})
+
+	p := n.profile()
+	p.SendWindowSize = 256
+	p.LingerTime = 5000
+	a := n.join("10.0.0.1", p)
+
+	var recorders []*recorder
+	var sessions []*lrmp.Lrmp
+
+	for i := 2; i <= 3; i++ {
+		r := &recorder{}
+		p := n.profile()
+		p.Handler = r
+		p.RcvWindowSize = 256
+		recorders = append(recorders, r)
+		sessions = append(sessions, n.join(fmt.Sprintf("10.0.0.%d", i), p))
+	}
+
+	sendPackets(t, a, 0, 100)
+
+	closed := closeAsync(context.Background(), a)
+
+	/*
+	 * the repairs go on while lingering, the link is then repaired so that
+	 * the last BYE is not lost too.
+	 */
+	n.runUntil(time.Minute, func() bool { return recorders[0].count() == 100 && recorders[1].count() == 100 })
+
+	for i := 2; i <= 3; i++ {
+		n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP(fmt.Sprintf("10.0.0.%d", i)), vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	}
+
+	if !n.runUntil(2*time.Minute, func() bool { done, _ := closed(); return done }) {
+		t.Fatal("Close has not returned")
+	}
+	if _, err := closed(); err != nil {
+		t.Fatal(err)
+	}
+
+	n.run(5 * time.Second)
+
+	want := []int{lrmp.MEMBER_JOINED, lrmp.MEMBER_SENDER, lrmp.END_OF_SEQUENCE, lrmp.MEMBER_LEFT}
+
+	for i, r := range recorders {
+		checkInOrder(t, r, 100)
+
+		if got := r.eventsOf("10.0.0.1"); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("receiver %d got events %v about the sender, want %v", i, got, want)
+		}
+		if members := sessions[i].Members(); len(members.Senders) != 0 {
+			t.Fatalf("receiver %d still has senders %v", i, members.Senders)
+		}
+	}
+}
+
+/**
+ * waits until the goroutines started since the test began have exited.
+ */
+func checkNoGoroutines(t *testing.T, before int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines left, %d before", runtime.NumGoroutine(), before)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCloseSendsQueuedPackets(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+
+	p := slowProfile(n)
+	p.MaxRate = 800
+	p.LingerTime = 2000
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	b := n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 50)
+
+	start := n.clock.Now()
+	closed := closeAsync(context.Background(), a)
+
+	if !n.runUntil(time.Minute, func() bool { done, _ := closed(); return done }) {
+		t.Fatal("Close has not returned")
+	}
+	if _, err := closed(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* the queue is drained first, then the sender lingers */
+
+	checkInOrder(t, r, 50)
+
+	if d := n.clock.Now().Sub(start); d < 2*time.Second {
+		t.Fatalf("Close returned after %v, before the linger time", d)
+	}
+
+	if err := a.Send(newPacket("x")); err != lrmp.ErrClosed {
+		t.Fatalf("Send after Close returned %v", err)
+	}
+	if err := a.TrySend(newPacket("x")); err != lrmp.ErrClosed {
+		t.Fatalf("TrySend after Close returned %v", err)
+	}
+	if err := a.Close(context.Background()); err != lrmp.ErrClosed {
+		t.Fatalf("second Close returned %v", err)
+	}
+
+	b.Stop()
+
+	checkNoGoroutines(t, before)
+}
+
+func TestCloseWithoutDataDoesNotLinger(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", n.profile())
+
+	if err := a.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloseContextDone(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", slowProfile(n))
+
+	sendPackets(t, a, 0, 1)
+	waitFor(t, func() bool { return a.QueueLen() == 0 })
+	sendPackets(t, a, 1, 10)
+
+	/* the clock is not advanced, the queue cannot be drained in time */
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := a.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Close returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	/* the session is stopped anyway */
+
+	if err := a.Send(newPacket("x")); err != lrmp.ErrClosed {
+		t.Fatalf("Send after Close returned %v", err)
+	}
+
+	checkNoGoroutines(t, before)
+}
+
+func TestCloseContextDoneWhileLingering(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	p := n.profile()
+	p.LingerTime = 60000
+	a := n.join("10.0.0.1", p)
+
+	sendPackets(t, a, 0, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := closeAsync(ctx, a)
+
+	n.run(time.Second)
+
+	if done, _ := closed(); done {
+		t.Fatal("Close returned before the linger time")
+	}
+
+	cancel()
+
+	waitFor(t, func() bool { done, _ := closed(); return done })
+
+	if _, err := closed(); err != context.Canceled {
+		t.Fatalf("Close returned %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestStopDuringClose(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", slowProfile(n))
+
+	sendPackets(t, a, 0, 1)
+	waitFor(t, func() bool { return a.QueueLen() == 0 })
+	sendPackets(t, a, 1, 50)
+
+	closed := closeAsync(context.Background(), a)
+
+	/* the queue cannot be drained as the clock is not advanced */
+
+	time.Sleep(10 * time.Millisecond)
+
+	a.Stop()
+
+	waitFor(t, func() bool { done, _ := closed(); return done })
+
+	if _, err := closed(); err != lrmp.ErrClosed {
+		t.Fatalf("Close returned %v, want %v", err, lrmp.ErrClosed)
+	}
+}
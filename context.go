@@ -1,11 +1,27 @@
 package lrmp
 
-import "net"
+import (
+	"net"
+	"sync"
+)
 
 type Context struct {
+	/*
+	 * the protocol state is shared by the reader, the timers, the sender
+	 * and the API, mu protects it. Upcalls to the handler are queued while
+	 * holding mu and made in order after releasing it, so that the handler
+	 * can call back into the session. dispatching is set while a goroutine
+	 * makes the queued upcalls, the others only add to the queue.
+	 */
+	mu          sync.Mutex
+	upcalls     []upcall
+	dispatching bool
+
 	whoami  *sender
 	profile *Profile
 	stats   Stats
+	clock   Clock
+	timer   *timerManager
 
 	/* control objects */
 
@@ -13,9 +29,12 @@ type Context struct {
 	sender  *flow
 	recover *recovery
 	sm      *entityManager
+	evict   *eviction
 
 	/* flow/congestion control data, rate is in bytes/sec */
 
+	minRate       int
+	maxRate       int
 	adjust        int /* scaled by a factor of 8 */
 	curRate       int
 	actualRate    int
@@ -24,14 +43,17 @@ type Context struct {
 
 	/* output */
 
-	sendQueue            chan *Packet
-	resendQueue          packetQueue
-	senderReportInterval int
-	rcvReportSelInterval int
+	sendQueue   chan *Packet
+	resendQueue packetQueue
+}
+
+type upcall struct {
+	pack  *Packet
+	event int
+	data  Event
 }
 
 var maxQueueSize = 16
-var timer = newTimerManager()
 
 const (
 	BigDecrease    = 2
@@ -43,73 +65,150 @@ const (
 	BigIncrease    = 16
 )
 
-func newContext(ip net.IP, ttl int) *Context {
-	ctx := Context{}
+func newContext(ip net.IP, ttl int, clock Clock) *Context {
+	ctx := Context{clock: clock}
+	ctx.timer = newTimerManager(clock)
 	ctx.sendQueue = make(chan *Packet, 1000)
-	ctx.senderReportInterval = 4000
-	ctx.rcvReportSelInterval = 30000
 	ctx.sndInterval = 100
 	ctx.adjust = SmallIncrease
-	ctx.sm = newEntityManager(ip)
+	ctx.sm = newEntityManager(ip, &ctx)
 	ctx.sender = newFlow(&ctx)
 	ctx.recover = newRecovery(ttl, &ctx)
+	ctx.evict = newEviction(&ctx)
 	return &ctx
 }
 
+/**
+ * sets the profile, either at creation or on a running session. The packets
+ * cached are kept, so that the repairs of the data already sent and the
+ * reception in progress go on. The clock cannot be changed. The profile
+ * must be valid.
+ */
 func (c *Context) setProfile(prof *Profile) {
 
 	/* keep a cloned profile to prevent change by upper layer */
 
 	profile := *prof
+	profile.Clock = c.clock
 
-	if profile.sendWindowSize >= 32 {
-		c.whoami.cacheSize = profile.sendWindowSize
-		c.whoami.initCache(profile.sendWindowSize)
+	c.whoami.resizeCache(profile.SendWindowSize)
+
+	if c.profile != nil && c.profile.RcvWindowSize != profile.RcvWindowSize {
+		for _, e := range c.sm.entities {
+			if s, isSender := e.(*sender); isSender && s != c.whoami {
+				s.resizeCache(profile.RcvWindowSize)
+			}
+		}
 	}
 
 	c.sm.profile = &profile
 	c.profile = c.sm.profile
 
 	if isDebug() {
-		logDebug("rcv/snd window:", profile.rcvWindowSize, "/", profile.sendWindowSize)
+		logDebug("rcv/snd window:", profile.RcvWindowSize, "/", profile.SendWindowSize)
 	}
 
 	/*
-	 * check the data rate and converts kilo bits/sec to bytes/sec.
+	 * converts the data rate from kilo bits/sec to bytes/sec.
 	 */
-	if profile.minRate <= 0 {
-
-		/* the rate should be greater than zero */
-
-		profile.minRate = 125
-	} else {
-		profile.minRate = (profile.minRate * 1000) / 8
-	}
-
-	profile.maxRate = (profile.maxRate * 1000) / 8
-
-	if profile.maxRate <= profile.minRate {
-		profile.maxRate = profile.minRate
-	}
+	c.minRate = (profile.MinRate * 1000) / 8
+	c.maxRate = (profile.MaxRate * 1000) / 8
 
 	/* init for the first time only */
 
 	if c.curRate == 0 {
-		c.curRate = (profile.minRate + profile.maxRate) / 2
+		c.curRate = (c.minRate + c.maxRate) / 2
+
+		c.sndInterval = MTU * 1000 / c.curRate
+	} else if c.curRate < c.minRate || c.curRate > c.maxRate {
 
-		if c.curRate < profile.minRate {
-			c.curRate = profile.minRate
+		/* the bounds have changed, restart from the nearest one */
+
+		if c.curRate < c.minRate {
+			c.curRate = c.minRate
+		} else {
+			c.curRate = c.maxRate
 		}
 
 		c.sndInterval = MTU * 1000 / c.curRate
 	}
 
-	c.checkInterval = profile.sendWindowSize / 8
+	c.checkInterval = profile.SendWindowSize / 8
 
 	if c.checkInterval < 4 {
 		c.checkInterval = 4
 	}
 	if isDebug() {
-		logDebug("min/cur/max rate: ", profile.minRate, "/", c.curRate, "/", profile.maxRate, " send/check interval: ", c.sndInterval, "/", c.checkInterval)
+		logDebug("min/cur/max rate: ", c.minRate, "/", c.curRate, "/", c.maxRate, " send/check interval: ", c.sndInterval, "/", c.checkInterval)
 	}
 }
+
+/**
+ * queues the delivery of a packet to the handler.
+ */
+func (c *Context) processData(pack *Packet) {
+	if c.profile.Handler != nil {
+		c.upcalls = append(c.upcalls, upcall{pack: pack})
+	}
+}
+
+/**
+ * queues the notification of an event to the handler.
+ */
+func (c *Context) processEvent(event int, data Event) {
+	if c.profile.Handler != nil {
+		c.upcalls = append(c.upcalls, upcall{event: event, data: data})
+	}
+}
+
+/**
+ * queues the pending loss report of the sender, if any. Must be called
+ * before queueing another upcall about the sender.
+ */
+func (c *Context) flushLoss(s *sender) {
+	if s.pendingLoss != nil {
+		c.processEvent(UNRECOVERABLE_SEQUENCE_ERROR, s.pendingLoss)
+		s.pendingLoss = nil
+	}
+}
+
+func (c *Context) lock() {
+	c.mu.Lock()
+}
+
+/**
+ * releases the protocol lock and makes the pending upcalls, unless another
+ * goroutine is already making them: that one then makes these as well, in
+ * order. No lock is held during an upcall, so the handler may call the API.
+ */
+func (c *Context) unlock() {
+	if len(c.upcalls) == 0 || c.dispatching {
+		c.mu.Unlock()
+		return
+	}
+
+	c.dispatching = true
+
+	for len(c.upcalls) > 0 {
+		upcalls := c.upcalls
+		handler := c.profile.Handler
+
+		c.upcalls = nil
+
+		c.mu.Unlock()
+
+		for _, u := range upcalls {
+			if u.pack != nil {
+				handler.ProcessData(u.pack)
+			} else {
+				handler.ProcessEvent(u.event, u.data)
+			}
+		}
+
+		c.mu.Lock()
+	}
+
+	c.dispatching = false
+
+	c.mu.Unlock()
+}
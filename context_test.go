@@ -0,0 +1,154 @@
+package lrmp_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/**
+ * echoes each packet received and reads the state of the session from the
+ * upcalls, which are made without holding the protocol lock.
+ */
+type echoHandler struct {
+	recorder
+	l *lrmp.Lrmp
+}
+
+func (h *echoHandler) ProcessData(p *lrmp.Packet) {
+	h.recorder.ProcessData(p)
+
+	h.l.Stats()
+	h.l.Members()
+
+	if err := h.l.Send(newPacket("echo " + string(p.GetDataBuffer()[:p.GetDataLength()]))); err != nil {
+		panic(err)
+	}
+}
+
+func (h *echoHandler) ProcessEvent(event int, data interface{}) {
+	h.recorder.ProcessEvent(event, data)
+
+	h.l.Domains()
+	h.l.SetRate(8, 64)
+}
+
+func TestHandlerCallsBackIntoSession(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+
+	r := &recorder{}
+	p := n.profile()
+	p.Handler = r
+	a := n.join("10.0.0.1", p)
+
+	h := &echoHandler{}
+	p = n.profile()
+	p.Handler = h
+	h.l = n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 20)
+
+	if !n.runUntil(time.Minute, func() bool { return r.count() == 20 }) {
+		t.Fatalf("received %d echoes", r.count())
+	}
+	checkInOrder(t, &h.recorder, 20)
+
+	for i, d := range r.received() {
+		if d != "echo "+strconv.Itoa(i) {
+			t.Fatalf("echo %d is %q", i, d)
+		}
+	}
+}
+
+/**
+ * holds the first packet until the clock has passed the time sent on until,
+ * then calls back into the session.
+ */
+type holdingHandler struct {
+	recorder
+	l      *lrmp.Lrmp
+	clock  *lrmp.VirtualClock
+	inside chan struct{}
+	until  chan time.Time
+}
+
+func (h *holdingHandler) ProcessData(p *lrmp.Packet) {
+	h.recorder.ProcessData(p)
+
+	if h.count() > 1 {
+		return
+	}
+
+	close(h.inside)
+
+	until := <-h.until
+
+	for deadline := time.Now().Add(2 * time.Second); h.clock.Now().Before(until) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	h.l.Stats()
+	h.l.Members()
+}
+
+/*
+ * a sender is dropped by a timer while the handler, called by the reader,
+ * calls back into the session. The events of the timer are made after the
+ * upcall in progress.
+ */
+func TestTimerEventDuringCallback(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+	n.step = 100 * time.Millisecond
+
+	h := &holdingHandler{clock: n.clock, inside: make(chan struct{}), until: make(chan time.Time)}
+	p := n.profile()
+	p.Handler = h
+	p.RcvDropTime = 10000
+	p.SndDropTime = 30000
+	h.l = n.join("10.0.0.1", p)
+
+	b := n.join("10.0.0.2", n.profile())
+
+	/* the local timers only run once data was sent */
+
+	sendPackets(t, h.l, 0, 1)
+	sendPackets(t, b, 0, 1)
+
+	n.runUntil(5*time.Second, func() bool {
+		select {
+		case <-h.inside:
+			return true
+		default:
+			return false
+		}
+	})
+
+	b.Stop()
+	h.until <- n.clock.Now().Add(60 * time.Second)
+
+	done := make(chan struct{})
+	go func() {
+		n.run(60 * time.Second)
+		close(done)
+	}()
+
+	waitFor(t, func() bool {
+		select {
+		case <-done:
+			return true
+		default:
+			return false
+		}
+	})
+
+	types := h.eventTypes()
+	if len(types) < 2 || types[len(types)-2] != lrmp.END_OF_SEQUENCE || types[len(types)-1] != lrmp.MEMBER_LEFT {
+		t.Fatalf("events %v, want END_OF_SEQUENCE then MEMBER_LEFT last", types)
+	}
+	if len(h.l.Members().Senders) != 0 {
+		t.Fatal("sender not dropped")
+	}
+}
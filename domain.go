@@ -26,6 +26,18 @@ func (lh *lossHistory) contains(ev *lossEvent) bool {
 }
 
 const historySize = 16
+const mrttHistorySize = 64
+
+/**
+ * MRTTSample records an update of the mean round trip time of a domain.
+ * RTT is the measured sample and MRTT the smoothed value after the update,
+ * both in millis.
+ */
+type MRTTSample struct {
+	Time time.Time
+	RTT  int
+	MRTT int
+}
 
 type domain struct {
 	lastTimeToggle time.Time
@@ -36,15 +48,52 @@ type domain struct {
 	parent         *domain
 	stats          DomainStats
 	scope          int
+	mrtt           int // in 1/8 millisecs
 	initialMRTT    int
+	clock          Clock
+	mrttHistory    []MRTTSample
 }
 
+/**
+ * updates the mean round trip time with a new sample taken from a NACK
+ * reply or a receiver report. mrtt is kept in 1/8 millis and smoothed with
+ * a gain of 1/8, samples outside MinRTTValue and MaxRTTValue are ignored.
+ */
 func (d *domain) updateMRTT(rtt int) {
+	if rtt <= MinRTTValue || rtt >= MaxRTTValue {
+		return
+	}
+
+	d.setMRTT(d.mrtt+rtt-(d.mrtt>>3), rtt)
+
+	/* the mean round trip time of a child domain can not be larger */
+
+	for child := d.child; child != nil; child = child.child {
+		if child.mrtt > d.mrtt {
+			child.setMRTT(d.mrtt, rtt)
+		}
+	}
+}
+
+func (d *domain) setMRTT(mrtt int, rtt int) {
+	d.mrtt = mrtt
+	d.stats.MRTT = time.Duration(mrtt>>3) * time.Millisecond
+
+	if len(d.mrttHistory) >= mrttHistorySize {
+		d.mrttHistory = d.mrttHistory[1:]
+	}
+
+	d.mrttHistory = append(d.mrttHistory, MRTTSample{Time: d.clock.Now(), RTT: rtt, MRTT: mrtt >> 3})
+
+	if isDebug() {
+		logDebug("mrtt=", mrtt>>3, " rtt=", rtt, " scope=", d.scope)
+	}
 }
 func (d *domain) setChild(child *domain) {
 	d.child = child
 	d.child.parent = d
-	d.stats.childScope = child.scope
+	d.child.stats.ParentScope = d.scope
+	d.stats.ChildScope = child.scope
 
 }
 func (d *domain) checkState() {
@@ -54,25 +103,25 @@ func (d *domain) checkState() {
 	if d.parent == nil {
 		return
 	}
-	if d.stats.enabled {
+	if d.stats.Enabled {
 		if d.failedNack > DisableTries {
 			d.disable()
 		}
 	} else {
-		if time.Now().Sub(d.lastTimeToggle) > time.Duration(time.Millisecond*MaxDisableTime) {
+		if d.clock.Now().Sub(d.lastTimeToggle) > time.Duration(time.Millisecond*MaxDisableTime) {
 			d.enable()
 		}
 	}
 }
 
 func (d *domain) enable() {
-	if d.stats.enabled {
+	if d.stats.Enabled {
 		return
 	}
 
-	d.stats.enabled = true
+	d.stats.Enabled = true
 	d.failedNack = 0
-	d.lastTimeToggle = time.Now()
+	d.lastTimeToggle = d.clock.Now()
 
 	if d.parent != nil {
 		d.parent.enable()
@@ -82,12 +131,12 @@ func (d *domain) enable() {
 	}
 }
 func (d *domain) disable() {
-	if d.parent == nil || !d.stats.enabled {
+	if d.parent == nil || !d.stats.Enabled {
 		return
 	}
 
-	d.stats.enabled = false
-	d.lastTimeToggle = time.Now()
+	d.stats.Enabled = false
+	d.lastTimeToggle = d.clock.Now()
 
 	if d.child != nil {
 		d.child.disable()
@@ -97,12 +146,12 @@ func (d *domain) disable() {
 	}
 }
 func (d *domain) isEnabled() bool {
-	return d.stats.enabled
+	return d.stats.Enabled
 
 }
 func (d *domain) isDuplicate(event *lossEvent) bool {
 	dup := false
-	slice := d.stats.mrtt >> 3
+	slice := d.mrtt >> 3
 
 	if event.source.interval < 200 {
 		slice += event.source.interval
@@ -151,16 +200,19 @@ func (d *domain) isDuplicate(event *lossEvent) bool {
 	return dup
 }
 
-func newDomain(ttl int) *domain {
-	d := domain{scope: ttl}
+func newDomain(ttl int, clock Clock) *domain {
+	d := domain{scope: ttl, clock: clock}
 
-	d.stats.enabled = true
-	d.stats.childScope = 0
+	d.stats.Scope = ttl
+	d.stats.ParentScope = -1
+	d.stats.Enabled = true
+	d.stats.ChildScope = 0
 
 	/* 200*(scope/63)^2 */
 
 	d.initialMRTT = getInitialRTT(d.scope)
-	d.stats.mrtt = d.initialMRTT << 3
+	d.mrtt = d.initialMRTT << 3
+	d.stats.MRTT = time.Duration(d.initialMRTT) * time.Millisecond
 
 	return &d
 }
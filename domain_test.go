@@ -0,0 +1,48 @@
+package lrmp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUpdateMRTT(t *testing.T) {
+	c := NewVirtualClock(epoch)
+
+	top := newDomain(63, c)
+	low := newDomain(15, c)
+	top.setChild(low)
+
+	if top.stats.MRTT != 200*time.Millisecond || low.stats.MRTT != 12*time.Millisecond {
+		t.Fatalf("initial mrtt %v and %v", top.stats.MRTT, low.stats.MRTT)
+	}
+
+	/* smoothed with a gain of 1/8 */
+
+	top.updateMRTT(40)
+
+	if top.mrtt != 200*8-200+40 || top.stats.MRTT != 180*time.Millisecond {
+		t.Fatalf("mrtt %d, %v after a sample of 40ms", top.mrtt, top.stats.MRTT)
+	}
+
+	if h := top.mrttHistory[0]; h.RTT != 40 || h.MRTT != 180 {
+		t.Fatalf("first sample %+v", h)
+	}
+
+	/* out of range samples are ignored */
+
+	top.updateMRTT(MaxRTTValue)
+
+	if top.stats.MRTT != 180*time.Millisecond {
+		t.Fatalf("mrtt %v after an out of range sample", top.stats.MRTT)
+	}
+
+	/* a child domain is never slower than its parent */
+
+	for i := 0; i < 100; i++ {
+		top.updateMRTT(MinRTTValue + 1)
+	}
+
+	if low.mrtt != top.mrtt || low.stats.MRTT != top.stats.MRTT {
+		t.Fatalf("child mrtt %v, parent %v", low.stats.MRTT, top.stats.MRTT)
+	}
+}
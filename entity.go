@@ -1,13 +1,14 @@
 package lrmp
 
 import (
-	"bytes"
 	"math/rand"
 	"net"
 	"strconv"
 	"time"
 )
 
+/* the defaults of the profile */
+
 const rcvDropTime = 60000
 const sndDropTime = 600000
 const maxSrc = 128
@@ -23,8 +24,15 @@ type Entity interface {
 	getDistance() int
 	setDistance(distance int)
 	incNack()
+	getNacks() int
 	setRTT(rtt int)
 	getRTT() int
+	incDecrease()
+	ageDecreases()
+	getDecreases() int
+	getRecentDecreases() int
+	setIgnored(ignored bool)
+	isIgnored() bool
 }
 
 type EntityImpl struct {
@@ -37,6 +45,12 @@ type EntityImpl struct {
 	rtt int
 	// approx number of hops from local site.
 	distance int
+	// number of rate decreases caused.
+	decreases int
+	// recent rate decreases caused, in 1/8 and aged at each rate check.
+	recentDecreases int
+	// congestion signals ignored.
+	ignored bool
 }
 
 func (e *EntityImpl) String() string {
@@ -54,6 +68,9 @@ func (e *EntityImpl) setID(id uint32) {
 func (e *EntityImpl) incNack() {
 	e.nack++
 }
+func (e *EntityImpl) getNacks() int {
+	return e.nack
+}
 
 func (e *EntityImpl) getAddress() net.IP {
 	return e.ipAddr
@@ -77,6 +94,34 @@ func (e *EntityImpl) reset() {
 	e.nack = 0
 	e.lastTimeHeard = time.Time{}
 	e.distance = 255
+	e.decreases = 0
+	e.recentDecreases = 0
+	e.ignored = false
+}
+
+func (e *EntityImpl) incDecrease() {
+	e.decreases++
+	e.recentDecreases += 8
+}
+
+/**
+ * forgets one eighth of the recent rate decreases, rounded up so that they
+ * are forgotten once the entity no longer causes decreases.
+ */
+func (e *EntityImpl) ageDecreases() {
+	e.recentDecreases -= (e.recentDecreases + 7) >> 3
+}
+func (e *EntityImpl) getRecentDecreases() int {
+	return e.recentDecreases
+}
+func (e *EntityImpl) getDecreases() int {
+	return e.decreases
+}
+func (e *EntityImpl) setIgnored(ignored bool) {
+	e.ignored = ignored
+}
+func (e *EntityImpl) isIgnored() bool {
+	return e.ignored
 }
 
 func (e *EntityImpl) getDistance() int {
@@ -88,11 +133,14 @@ func (e *EntityImpl) setDistance(distance int) {
 
 type entityManager struct {
 	entities map[uint32]Entity
-	whoami   *sender
-	profile  *Profile
+	/* the IDs which said BYE, with the time they left */
+	gone    map[uint32]time.Time
+	whoami  *sender
+	profile *Profile
+	cxt     *Context
 }
 
-func newEntityManager(ip net.IP) *entityManager {
+func newEntityManager(ip net.IP, cxt *Context) *entityManager {
 	i := allocateID()
 
 	var initSeqno int64 = 0
@@ -101,7 +149,7 @@ func newEntityManager(ip net.IP) *entityManager {
 		initSeqno = int64(rand.Int() & 0xffff)
 	}
 
-	em := entityManager{entities: make(map[uint32]Entity)}
+	em := entityManager{entities: make(map[uint32]Entity), gone: make(map[uint32]time.Time), cxt: cxt}
 
 	em.whoami = newSender(i, ip, initSeqno)
 
@@ -109,7 +157,9 @@ func newEntityManager(ip net.IP) *entityManager {
 		logDebug("local user=", em.whoami, " seqno=", em.whoami.expected)
 	}
 
-	em.add(em.whoami)
+	/* the profile is not set yet, no pruning */
+
+	em.entities[em.whoami.getID()] = em.whoami
 
 	return &em
 }
@@ -123,21 +173,25 @@ func (m *entityManager) lookup(srcId uint32, ip net.IP) Entity {
 	s := m.entities[srcId]
 
 	if s != nil {
-		if !bytes.Equal(s.getAddress(), ip) {
+		if !s.getAddress().Equal(ip) {
 			_, ok := s.(*sender)
 			if ok {
 				return nil // if the registered is a sender, reject new one
 			}
 
-			silence := millis(time.Now().Sub(s.getLastTimeHeard()))
+			silence := millis(m.cxt.clock.Now().Sub(s.getLastTimeHeard()))
 
-			if silence < rcvDropTime {
+			if silence < int64(m.profile.RcvDropTime) {
 				return nil
 			}
 
+			previous := identityOf(s)
+
 			s.setAddress(ip)
 			s.reset()
 
+			m.cxt.processEvent(MEMBER_REPLACED, &MemberReplaced{Member: identityOf(s), Previous: previous})
+
 			return s
 		} else {
 			return s
@@ -153,15 +207,19 @@ func (m *entityManager) lookup(srcId uint32, ip net.IP) Entity {
 		_, isSender := e.(*sender)
 
 		if e != m.whoami && !isSender {
-			if bytes.Equal(e.getAddress(), ip) {
-				silence := millis(time.Now().Sub(e.getLastTimeHeard()))
+			if e.getAddress().Equal(ip) {
+				silence := millis(m.cxt.clock.Now().Sub(e.getLastTimeHeard()))
+
+				if silence >= int64(m.profile.RcvDropTime) {
+					previous := identityOf(e)
 
-				if silence >= rcvDropTime {
 					m.remove(e)
 					e.setID(srcId)
 					m.add(e)
 					e.reset()
 
+					m.cxt.processEvent(MEMBER_REPLACED, &MemberReplaced{Member: identityOf(e), Previous: previous})
+
 					return e
 				}
 			}
@@ -174,6 +232,8 @@ func (m *entityManager) lookup(srcId uint32, ip net.IP) Entity {
 
 	m.add(s)
 
+	m.cxt.processEvent(MEMBER_JOINED, &MemberJoined{Member: identityOf(s)})
+
 	return s
 }
 
@@ -181,16 +241,60 @@ func (m *entityManager) remove(e Entity) {
 	if e != m.whoami {
 		delete(m.entities, e.getID())
 
-		if _, isSender := e.(*sender); isSender {
-			if m.profile.Handler != nil {
-				m.profile.Handler.ProcessEvent(END_OF_SEQUENCE, e)
-			}
+		if s, isSender := e.(*sender); isSender {
+			s.lost = true
+			m.cxt.flushLoss(s)
+			m.cxt.processEvent(END_OF_SEQUENCE, &EndOfSequence{Source: identityOf(e)})
 		}
 	}
 }
+
+/**
+ * removes an entity which has said BYE. The packets still received from it,
+ * e.g. its repairs for other receivers, are ignored until the receiver drop
+ * time so that it does not join again.
+ */
+func (m *entityManager) bye(e Entity) {
+	m.gone[e.getID()] = m.cxt.clock.Now()
+	m.leave(e)
+}
+
+/**
+ * returns true if the ID has said BYE.
+ */
+func (m *entityManager) hasGone(srcId uint32) bool {
+	_, gone := m.gone[srcId]
+	return gone
+}
+
+/**
+ * removes an entity which has left the session, either silently or with a
+ * BYE.
+ */
+func (m *entityManager) leave(e Entity) {
+	if e == m.whoami {
+		return
+	}
+
+	delete(m.entities, e.getID())
+
+	s, isSender := e.(*sender)
+
+	if isSender {
+
+		/* the losses still pending for it are given up */
+
+		s.lost = true
+		m.cxt.flushLoss(s)
+		m.cxt.processEvent(END_OF_SEQUENCE, &EndOfSequence{Source: identityOf(e)})
+	}
+
+	m.cxt.processEvent(MEMBER_LEFT, &MemberLeft{Member: identityOf(e), Sender: isSender})
+}
+
 func (m *entityManager) add(e Entity) {
-	if len(m.entities) > maxSrc {
-		for maxSilence := int64(rcvDropTime); len(m.entities) > maxSrc; {
+	if len(m.entities) > m.profile.MaxSrc {
+		for maxSilence := int64(m.profile.RcvDropTime); len(m.entities) > m.profile.MaxSrc; {
 			m.prune(maxSilence)
 
 			if maxSilence > 10000 {
@@ -204,16 +308,27 @@ func (m *entityManager) add(e Entity) {
 	m.entities[e.getID()] = e
 }
 
+/**
+ * removes the senders silent for SndDropTime and the receivers silent for
+ * maxSilence, through leave so END_OF_SEQUENCE is raised for each sender and
+ * MEMBER_LEFT for each entity. The BYE entries expire after RcvDropTime.
+ */
 func (m *entityManager) prune(maxSilence int64) {
-	now := time.Now()
+	now := m.cxt.clock.Now()
+
+	for id, t := range m.gone {
+		if millis(now.Sub(t)) >= int64(m.profile.RcvDropTime) {
+			delete(m.gone, id)
+		}
+	}
 
 	for _, e := range m.entities {
 		if e != m.whoami {
 			silence := millis(now.Sub(e.getLastTimeHeard()))
-			if silence >= sndDropTime {
-				delete(m.entities, e.getID())
-			} else if _, isSender := e.(*sender); !isSender && silence >= maxSilence {
-				delete(m.entities, e.getID())
+			_, isSender := e.(*sender)
+
+			if silence >= int64(m.profile.SndDropTime) || (!isSender && silence >= maxSilence) {
+				m.leave(e)
 			}
 		}
 	}
@@ -232,7 +347,7 @@ func (m *entityManager) demux(srcId uint32, ip net.IP) Entity {
 	if s == nil {
 		return nil
 	}
-	if !bytes.Equal(s.getAddress(), ip) {
+	if !s.getAddress().Equal(ip) {
 		return nil
 	}
 
@@ -246,13 +361,39 @@ func (m *entityManager) lookupSender(srcId uint32, ip net.IP, seqno int64) *send
 
 	if e == nil {
 		s = newSender(srcId, ip, seqno)
-		s.initCache(m.profile.rcvWindowSize)
+		s.initCache(m.profile.RcvWindowSize)
+
+		/* the ID may be known at another address */
+
+		previous := m.entities[srcId]
+
+		if p, isSender := previous.(*sender); isSender {
+
+			/* the data of the sender replaced ends here */
+
+			m.cxt.flushLoss(p)
+			m.cxt.processEvent(END_OF_SEQUENCE, &EndOfSequence{Source: identityOf(p)})
+		}
+
+		/* not pruned by add, it is replaced */
+
+		delete(m.entities, srcId)
+
 		m.add(s)
+
+		if previous != nil {
+			m.cxt.processEvent(MEMBER_REPLACED, &MemberReplaced{Member: identityOf(s), Previous: identityOf(previous)})
+		} else {
+			m.cxt.processEvent(MEMBER_JOINED, &MemberJoined{Member: identityOf(s)})
+		}
+		m.cxt.processEvent(MEMBER_SENDER, &MemberSender{Member: identityOf(s)})
 	} else if _, isSender := e.(*sender); !isSender {
 		s = newSender(srcId, ip, seqno)
-		s.initCache(m.profile.rcvWindowSize)
+		s.initCache(m.profile.RcvWindowSize)
 		m.remove(e)
 		m.add(s)
+
+		m.cxt.processEvent(MEMBER_SENDER, &MemberSender{Member: identityOf(s)})
 	} else {
 		s = e.(*sender)
 	}
@@ -0,0 +1,54 @@
+package lrmp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+func TestSilentSenderDroppedAfterSndDropTime(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	r := &recorder{}
+	p := n.profile()
+	p.Handler = r
+	p.RcvDropTime = 10000
+	p.SndDropTime = 30000
+
+	a := n.join("10.0.0.1", p)
+	b := n.join("10.0.0.2", n.profile())
+
+	sendPackets(t, a, 0, 1)
+	sendPackets(t, b, 0, 1)
+
+	if !n.runUntil(5*time.Second, func() bool { return r.count() == 1 }) {
+		t.Fatal("packet of b not received")
+	}
+
+	b.Stop()
+	start := n.clock.Now()
+
+	n.step = 100 * time.Millisecond
+
+	/* a sender is kept past the receiver drop time */
+
+	n.run(15 * time.Second)
+
+	if len(a.Members().Senders) != 1 {
+		t.Fatal("sender dropped before SndDropTime")
+	}
+
+	if !n.runUntil(30*time.Second, func() bool { return len(a.Members().Senders) == 0 }) {
+		t.Fatal("sender not dropped")
+	}
+	if silence := n.clock.Now().Sub(start); silence < 30*time.Second {
+		t.Fatalf("sender dropped after %v", silence)
+	}
+
+	types := r.eventTypes()
+	if len(types) < 2 || types[len(types)-2] != lrmp.END_OF_SEQUENCE || types[len(types)-1] != lrmp.MEMBER_LEFT {
+		t.Fatalf("events %v, want END_OF_SEQUENCE then MEMBER_LEFT last", types)
+	}
+}
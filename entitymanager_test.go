@@ -0,0 +1,95 @@
+package lrmp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+type nopHandler struct{}
+
+func (h nopHandler) ProcessData(pack *Packet)                 {}
+func (h nopHandler) ProcessEvent(event int, data interface{}) {}
+
+/**
+ * returns the types and members of the events queued, and clears them.
+ */
+func takeEvents(cxt *Context) []string {
+	var events []string
+
+	for _, u := range cxt.upcalls {
+		var id Identity
+
+		switch e := u.data.(type) {
+		case *EndOfSequence:
+			id = e.Source
+		case *MemberJoined:
+			id = e.Member
+		case *MemberSender:
+			id = e.Member
+		case *MemberLeft:
+			id = e.Member
+		case *MemberReplaced:
+			id = e.Member
+		}
+		events = append(events, fmt.Sprint(u.event, "@", id.Addr))
+	}
+	cxt.upcalls = nil
+
+	return events
+}
+
+func TestMembershipEvents(t *testing.T) {
+	clock := NewVirtualClock(epoch)
+
+	cxt := newContext(net.ParseIP("10.0.0.1"), 1, clock)
+	defer cxt.sender.stop()
+
+	/* the sender goroutine is running */
+
+	cxt.mu.Lock()
+	defer cxt.mu.Unlock()
+
+	cxt.whoami = cxt.sm.whoami
+
+	profile := NewProfile()
+	profile.Handler = nopHandler{}
+	cxt.setProfile(profile)
+
+	check := func(want ...string) {
+		t.Helper()
+		if got := takeEvents(cxt); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("events %v, want %v", got, want)
+		}
+	}
+
+	receiver := cxt.sm.lookup(1, net.ParseIP("10.0.0.2"))
+	receiver.setLastTimeHeard(clock.Now())
+	check("6@10.0.0.2")
+
+	s := cxt.sm.lookupSender(2, net.ParseIP("10.0.0.3"), 100)
+	s.setLastTimeHeard(clock.Now())
+	check("6@10.0.0.3", "7@10.0.0.3")
+
+	/* a receiver starting to send */
+
+	cxt.sm.lookupSender(1, net.ParseIP("10.0.0.2"), 100).setLastTimeHeard(clock.Now())
+	check("7@10.0.0.2")
+
+	/* the ID of the sender now used at another address */
+
+	s = cxt.sm.lookupSender(2, net.ParseIP("10.0.0.4"), 200)
+	s.setLastTimeHeard(clock.Now())
+	check("2@10.0.0.3", "9@10.0.0.4", "7@10.0.0.4")
+
+	/* the silent senders are pruned */
+
+	clock.Advance(time.Duration(profile.SndDropTime) * time.Millisecond)
+	cxt.sm.prune(int64(profile.RcvDropTime))
+
+	events := takeEvents(cxt)
+	if len(events) != 4 || len(cxt.sm.entities) != 1 {
+		t.Fatalf("events %v after pruning, %d entities left", events, len(cxt.sm.entities))
+	}
+}
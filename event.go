@@ -0,0 +1,203 @@
+package lrmp
+
+import (
+	"net"
+	"strconv"
+)
+
+const (
+	Unknown = 0
+
+	/**
+	 * The error cause: out of buffer error, i.e., no enough buffer space.
+	 */
+	BufferOverrun = 1
+
+	/**
+	 * The error cause: maximum number of repair requests reached.
+	 */
+	MaxTriesReached = 2
+
+	/**
+	 * The error cause: the sender is lost.
+	 */
+	SenderLost = 3
+
+	/**
+	 * The error cause: the sender is gone.
+	 */
+	SenderGone = 4
+)
+
+/**
+ * the event type: the session was left due to a persistent high loss. The
+ * reception resumes on SESSION_REJOINED.
+ */
+const SESSION_LEFT = 3
+
+/**
+ * the event type: the session was joined again after SESSION_LEFT.
+ */
+const SESSION_REJOINED = 4
+
+/**
+ * the event type: the session is definitely abandoned since the high loss
+ * persists after MaxRejoins attempts. No more data is received.
+ */
+const SESSION_ABANDONED = 5
+
+/**
+ * the event type: a new entity is heard in the session.
+ */
+const MEMBER_JOINED = 6
+
+/**
+ * the event type: an entity starts sending data.
+ */
+const MEMBER_SENDER = 7
+
+/**
+ * the event type: an entity silent for too long is removed from the session.
+ */
+const MEMBER_LEFT = 8
+
+/**
+ * the event type: an entity is replaced by a new one, i.e. it has rejoined
+ * the session with a new ID at the same address, or its ID is now used at
+ * another address.
+ */
+const MEMBER_REPLACED = 9
+
+/**
+ * Identity identifies a session member.
+ */
+type Identity struct {
+	ID   uint32
+	Addr net.IP
+}
+
+func identityOf(e Entity) Identity {
+	return Identity{ID: e.getID(), Addr: e.getAddress()}
+}
+
+func (id Identity) String() string {
+	return strconv.FormatInt(int64(id.ID), 16) + "@" + id.Addr.String()
+}
+
+/**
+ * Event is the data passed to EventHandler.ProcessEvent, the concrete type
+ * depends on the event type and can be found with a type switch.
+ */
+type Event interface {
+	Type() int
+}
+
+/**
+ * SequenceError is the event UNRECOVERABLE_SEQUENCE_ERROR. The data of the
+ * source from sequence number First to Last inclusive will never be
+ * delivered, the reason is given by Cause.
+ */
+type SequenceError struct {
+	Source Identity
+	Cause  int
+	First  int64
+	Last   int64
+}
+
+func (e *SequenceError) Type() int {
+	return UNRECOVERABLE_SEQUENCE_ERROR
+}
+
+/**
+ * EndOfSequence is the event END_OF_SEQUENCE, the source is lost or gone.
+ */
+type EndOfSequence struct {
+	Source Identity
+}
+
+func (e *EndOfSequence) Type() int {
+	return END_OF_SEQUENCE
+}
+
+/**
+ * SessionLeft is the event SESSION_LEFT, Source is the sender whose data
+ * suffered the high loss.
+ */
+type SessionLeft struct {
+	Source Identity
+}
+
+func (e *SessionLeft) Type() int {
+	return SESSION_LEFT
+}
+
+/**
+ * SessionRejoined is the event SESSION_REJOINED.
+ */
+type SessionRejoined struct {
+	Attempt int
+}
+
+func (e *SessionRejoined) Type() int {
+	return SESSION_REJOINED
+}
+
+/**
+ * SessionAbandoned is the event SESSION_ABANDONED, Source is the sender
+ * whose data suffered the high loss.
+ */
+type SessionAbandoned struct {
+	Source Identity
+}
+
+func (e *SessionAbandoned) Type() int {
+	return SESSION_ABANDONED
+}
+
+/**
+ * MemberJoined is the event MEMBER_JOINED.
+ */
+type MemberJoined struct {
+	Member Identity
+}
+
+func (e *MemberJoined) Type() int {
+	return MEMBER_JOINED
+}
+
+/**
+ * MemberSender is the event MEMBER_SENDER.
+ */
+type MemberSender struct {
+	Member Identity
+}
+
+func (e *MemberSender) Type() int {
+	return MEMBER_SENDER
+}
+
+/**
+ * MemberLeft is the event MEMBER_LEFT, Sender tells if the member was
+ * sending data.
+ */
+type MemberLeft struct {
+	Member Identity
+	Sender bool
+}
+
+func (e *MemberLeft) Type() int {
+	return MEMBER_LEFT
+}
+
+/**
+ * MemberReplaced is the event MEMBER_REPLACED, Previous is the former
+ * identity of the member.
+ */
+type MemberReplaced struct {
+	Member   Identity
+	Previous Identity
+}
+
+func (e *MemberReplaced) Type() int {
+	return MEMBER_REPLACED
+}
@@ -0,0 +1,169 @@
+package lrmp
+
+import "time"
+
+/*
+ * Receiver self-eviction (draft section 7.3). A receiver which encounters a
+ * high loss rate while the sender does not reduce its rate leaves the
+ * session, so that its congested links are relieved, and tries to rejoin
+ * later. After several rejoin attempts it abandons the session.
+ */
+
+const (
+	evictionCheckInterval = 10000 /* millis */
+	evictionPeriods       = 3     /* consecutive checks with high loss */
+	rejoinDelay           = 60000 /* millis */
+	MaxRejoins            = 3
+	rejoinResetTime       = 600000 /* millis */
+)
+
+type eviction struct {
+	cxt        *Context
+	lastRejoin time.Time
+	left       bool
+	abandoned  bool
+	rejoins    int
+	task       *timerTask
+}
+
+func newEviction(cxt *Context) *eviction {
+	ev := eviction{cxt: cxt}
+	return &ev
+}
+
+/**
+ * returns true if the packets of the session must be ignored.
+ */
+func (ev *eviction) isOut() bool {
+	return ev.left || ev.abandoned
+}
+
+/**
+ * starts the periodic loss check if the policy is enabled.
+ */
+func (ev *eviction) start() {
+	if ev.cxt.profile.EvictionLossRate > 0 && ev.task == nil && !ev.abandoned {
+		ev.task = ev.cxt.timer.registerTimer(evictionCheckInterval, ev, nil)
+	}
+}
+
+/**
+ * checks the loss from each sender.
+ */
+func (ev *eviction) check(now time.Time) {
+	cxt := ev.cxt
+
+	/* only receivers leave */
+
+	if !cxt.whoami.lastTimeForData.IsZero() {
+		return
+	}
+
+	for _, e := range cxt.sm.entities {
+		s, isSender := e.(*sender)
+
+		if !isSender || s == cxt.whoami {
+			continue
+		}
+
+		/* the loss before repair */
+
+		lost := s.gaps - s.evictLost
+		expected := diff32(s.maxseq, s.evictMaxSeqno)
+
+		s.evictLost = s.gaps
+		s.evictMaxSeqno = s.maxseq
+
+		rate := 0
+
+		if expected > 0 && lost > 0 {
+			rate = lost * 100 / expected
+		}
+
+		/* high loss or reception failures, and the sender does not slow down */
+
+		if expected > 0 && (rate >= cxt.profile.EvictionLossRate || s.syncErrors > 0) && s.rate >= s.evictRate {
+			s.highLoss++
+		} else {
+			s.highLoss = 0
+		}
+
+		s.evictRate = s.rate
+		s.syncErrors = 0
+
+		if isDebug() {
+			logDebug("loss from ", s, " ", rate, "% high=", s.highLoss)
+		}
+
+		if s.highLoss >= evictionPeriods {
+			ev.leave(s, now)
+			return
+		}
+	}
+}
+
+func (ev *eviction) leave(s *sender, now time.Time) {
+	cxt := ev.cxt
+
+	if millis(now.Sub(ev.lastRejoin)) > rejoinResetTime {
+		ev.rejoins = 0
+	}
+
+	cxt.lrmp.session.leaveGroup()
+
+	if ev.rejoins >= MaxRejoins {
+		logError("abandoning the session, high loss from ", s)
+
+		ev.abandoned = true
+		cxt.processEvent(SESSION_ABANDONED, &SessionAbandoned{Source: identityOf(s)})
+	} else {
+		logError("leaving the session, high loss from ", s)
+
+		ev.left = true
+		cxt.processEvent(SESSION_LEFT, &SessionLeft{Source: identityOf(s)})
+	}
+
+	/* the members are heard again after rejoining */
+
+	cxt.lrmp.resetReception()
+}
+
+func (ev *eviction) rejoin(now time.Time) {
+	ev.left = false
+	ev.rejoins++
+	ev.lastRejoin = now
+
+	ev.cxt.lrmp.session.joinGroup()
+
+	if isDebug() {
+		logDebug("rejoined the session, attempt ", ev.rejoins)
+	}
+
+	ev.cxt.processEvent(SESSION_REJOINED, &SessionRejoined{Attempt: ev.rejoins})
+}
+
+func (ev *eviction) handleTimerTask(data interface{}, thetime time.Time) {
+	ev.cxt.lock()
+	defer ev.cxt.unlock()
+
+	ev.task = nil
+
+	if ev.left {
+		ev.rejoin(thetime)
+	} else {
+		ev.check(thetime)
+	}
+
+	if ev.left {
+		ev.task = ev.cxt.timer.registerTimer(rejoinDelay, ev, nil)
+	} else {
+		ev.start()
+	}
+}
+
+func (ev *eviction) stop() {
+	if ev.task != nil {
+		ev.cxt.timer.recallTimer(ev.task)
+		ev.task = nil
+	}
+}
@@ -0,0 +1,91 @@
+package lrmp_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/**
+ * returns the index of the first event of the given type, -1 if none.
+ */
+func (r *recorder) indexOf(event int) int {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, e := range r.events {
+		if e.Type() == event {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+ * a receiver with a persistent high loss from a sender which keeps its rate
+ * leaves the session, forgets the sender and hears it again after rejoining.
+ */
+func TestEvictionAndRejoin(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+	n.step = 50 * time.Millisecond
+
+	p := n.profile()
+	p.Throughput = lrmp.ConstantThroughput
+	p.MinRate = 16
+	p.MaxRate = 16
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	p.EvictionLossRate = 20
+	n.join("10.0.0.2", p)
+
+	lossy := vnet.LinkConfig{Loss: 0.5}
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), lossy)
+
+	go func() {
+		for a.Send(newPacket("x")) == nil {
+		}
+	}()
+
+	if !n.runUntil(5*time.Minute, func() bool { return r.indexOf(lrmp.SESSION_LEFT) >= 0 }) {
+		t.Fatalf("session not left, events %v", r.eventTypes())
+	}
+
+	/* the sender is removed after the session is left */
+
+	left := r.indexOf(lrmp.SESSION_LEFT)
+	want := fmt.Sprint([]int{lrmp.MEMBER_JOINED, lrmp.MEMBER_SENDER, lrmp.END_OF_SEQUENCE, lrmp.MEMBER_LEFT})
+
+	if got := r.eventsOf("10.0.0.1"); fmt.Sprint(got) != want {
+		t.Fatalf("events %v about the sender, want %v", got, want)
+	}
+	if r.indexOf(lrmp.MEMBER_LEFT) < left {
+		t.Fatalf("sender removed before the session is left: %v", r.eventTypes())
+	}
+
+	/* nothing is received until the rejoin */
+
+	count := r.count()
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{})
+
+	if !n.runUntil(2*time.Minute, func() bool { return r.indexOf(lrmp.SESSION_REJOINED) >= 0 }) {
+		t.Fatalf("session not rejoined, events %v", r.eventTypes())
+	}
+	if r.count() != count {
+		t.Fatalf("received %d packets while out of the session", r.count()-count)
+	}
+
+	if !n.runUntil(time.Minute, func() bool { return r.count() > count }) {
+		t.Fatal("nothing received after the rejoin")
+	}
+	if got := r.eventsOf("10.0.0.1"); len(got) < 5 || got[4] != lrmp.MEMBER_JOINED {
+		t.Fatalf("events %v about the sender after the rejoin", got)
+	}
+}
@@ -0,0 +1,320 @@
+package lrmp
+
+import "time"
+
+/*
+ * Forward error correction (draft section 9). The sender adds one redundant
+ * F_DATA packet per block of k consecutive reliable packets, carrying the
+ * exclusive or of their data. A receiver missing a single packet of a block
+ * rebuilds it from the parity and the other k-1 packets without sending a
+ * NACK. Receivers without FEC support simply ignore F_DATA packets.
+ *
+ * The FEC data starts with a word holding the exclusive or of the data
+ * lengths, so the packets protected can carry at most fecMaxData bytes.
+ *
+ * A receiver detects a loss when the next data packet arrives, usually
+ * before the parity of the block, so no NACK is sent for a source using FEC
+ * until the block holding the loss is complete. The parity of a partial
+ * block is only sent once the sender has been idle for a second, a loss in
+ * the tail of a transmission is then usually repaired by a NACK first.
+ */
+
+const fecHeaderLen = 16
+const fecMaxData = MTU - fecHeaderLen - 4
+const maxFecBlocks = 16
+
+/* silence in millis after which the parity of a block is no longer awaited */
+const maxFecDelay = 1000
+
+type fecEncoder struct {
+	base   int64
+	count  int
+	lenxor int
+	maxlen int
+	parity []byte
+}
+
+/**
+ * a received block of redundant data. The data packets of the block have
+ * the sequence numbers base, base+step, ..., base+(k-1)*step.
+ */
+type fecBlock struct {
+	base   int64
+	k      int
+	step   int64
+	lenxor int
+	parity []byte
+}
+
+func (e *fecEncoder) reset() {
+	e.count = 0
+	e.lenxor = 0
+	e.maxlen = 0
+}
+
+func (e *fecEncoder) add(p *Packet) {
+	if e.parity == nil {
+		e.parity = make([]byte, fecMaxData)
+	}
+	if e.count == 0 {
+		e.base = p.seqno
+		for i := range e.parity {
+			e.parity[i] = 0
+		}
+	}
+
+	data := p.GetDataBuffer()[:p.datalen]
+
+	for i, b := range data {
+		e.parity[i] ^= b
+	}
+
+	if p.datalen > e.maxlen {
+		e.maxlen = p.datalen
+	}
+
+	e.lenxor ^= p.datalen
+	e.count++
+}
+
+/**
+ * formats the F_DATA packet for the current block.
+ */
+func (e *fecEncoder) format(id uint32, scope int) []byte {
+	len := (fecHeaderLen + 4 + e.maxlen + 3) & 0xfffc
+
+	buff := make([]byte, len)
+
+	buff[0] = byte((VersionNumber << 6) | F_DATA_PT)
+	buff[1] = byte(scope)
+	shortToByte(len, buff, 2)
+	intToByte(int(id), buff, 4)
+	intToByte(int(e.base), buff, 8)
+
+	/* block size and number of redundant packets minus one, spacing minus one */
+
+	buff[12] = byte(e.count)
+	buff[13] = 0
+	buff[14] = 0
+	buff[15] = 0
+
+	shortToByte(e.lenxor, buff, fecHeaderLen)
+	copy(buff[fecHeaderLen+4:], e.parity[:e.maxlen])
+
+	pad := len - (fecHeaderLen + 4 + e.maxlen)
+
+	if pad > 0 {
+		buff[0] |= byte(padBit)
+		buff[len-1] = byte(pad)
+	}
+
+	return buff
+}
+
+/**
+ * adds a reliable packet just sent to the current FEC block, and sends the
+ * redundant packet once the block is complete.
+ */
+func (i *impl) fecEncode(pack *Packet) {
+	k := i.cxt.profile.FecBlockSize
+
+	if k <= 0 {
+		return
+	}
+
+	e := &i.fec
+
+	if pack.datalen > fecMaxData {
+
+		/* too large to be protected, close the block before it */
+
+		i.fecFlush()
+
+		return
+	}
+
+	e.add(pack)
+
+	if e.count >= k {
+		i.fecFlush()
+	}
+}
+
+/**
+ * sends the redundant packet for a partial block, e.g. when the
+ * transmission stops.
+ */
+func (i *impl) fecFlush() {
+	e := &i.fec
+
+	if e.count == 0 {
+		return
+	}
+
+	buff := e.format(i.cxt.whoami.getID(), i.ttl)
+
+	e.reset()
+
+	i.session.send(buff, len(buff), i.ttl)
+
+	i.cxt.whoami.setLastTimeHeard(i.cxt.clock.Now())
+	i.cxt.stats.FecPackets++
+	i.cxt.stats.DataBytes += int64(len(buff))
+}
+
+/**
+ * rebuilds lost packets of the source from the redundant data received so
+ * far. Returns true if at least one packet has been recovered.
+ */
+func (i *impl) fecRecover(s *sender) bool {
+	recovered := false
+
+	blocks := s.fecBlocks[:0]
+
+	for _, b := range s.fecBlocks {
+		var missing int64
+		nmissing := 0
+
+		for j := 0; j < b.k; j++ {
+			seqno := b.base + int64(j)*b.step
+
+			if !s.isCached(seqno) {
+				missing = seqno
+				nmissing++
+			}
+		}
+
+		last := b.base + int64(b.k-1)*b.step
+
+		if nmissing == 0 || diff32(last, s.expected) < 0 {
+
+			/* nothing to repair or too late */
+
+			continue
+		}
+
+		if nmissing > 1 || diff32(missing, s.expected) < 0 {
+			blocks = append(blocks, b)
+			continue
+		}
+
+		if i.fecRebuild(s, b, missing) {
+			recovered = true
+		}
+	}
+
+	s.fecBlocks = blocks
+
+	return recovered
+}
+
+func (i *impl) fecRebuild(s *sender, b *fecBlock, seqno int64) bool {
+	data := make([]byte, len(b.parity))
+
+	copy(data, b.parity)
+
+	datalen := b.lenxor
+
+	for j := 0; j < b.k; j++ {
+		seq := b.base + int64(j)*b.step
+
+		if seq == seqno {
+			continue
+		}
+
+		p := s.getPacket(seq)
+
+		for n, c := range p.GetDataBuffer()[:p.datalen] {
+			if n < len(data) {
+				data[n] ^= c
+			}
+		}
+
+		datalen ^= p.datalen
+	}
+
+	if datalen < 0 || datalen > len(data) {
+		logError("bad FEC block ", b.base, " from ", s)
+		return false
+	}
+
+	now := i.cxt.clock.Now()
+
+	/* rebuild a complete data packet, it may later be resent as a repair */
+
+	pack := NewPacket(true, datalen)
+	copy(pack.GetDataBuffer(), data[:datalen])
+	pack.datalen = datalen
+	pack.seqno = seqno
+	pack.source = s
+	pack.sender = s
+	pack.scope = i.ttl
+	pack.formatDataPacket(false, now)
+	pack.rcvSendTime = now
+
+	i.cxt.stats.FecRecovered++
+
+	if isDebug() {
+		logDebug("FEC recovered #", seqno, " from ", s)
+	}
+
+	s.incPackets()
+	s.incBytes(datalen)
+
+	if diff32(seqno, s.maxseq) > 0 {
+		s.maxseq = seqno
+	}
+
+	if diff32(seqno, s.expected) == 0 {
+		s.putPacket(pack)
+
+		for pack != nil {
+			s.incExpected()
+			i.deliverData(pack)
+
+			pack = s.getPacket(s.expected)
+		}
+	} else {
+		s.putPacket(pack)
+		i.deliverUnordered(pack)
+	}
+
+	return true
+}
+
+/**
+ * keeps the most recent blocks of redundant data of the source. Once FEC is
+ * in use, delivered packets stay in the cache to allow the reconstruction.
+ */
+func (s *sender) addFecBlock(b *fecBlock) {
+	s.fecSeen = true
+	s.fecBlockSize = b.k
+	s.fecNext = b.base + int64(b.k)*b.step
+
+	if len(s.fecBlocks) >= maxFecBlocks {
+		s.fecBlocks = s.fecBlocks[1:]
+	}
+
+	s.fecBlocks = append(s.fecBlocks, b)
+}
+
+/**
+ * returns true if the parity of the block holding the given lost packet is
+ * still to come, i.e. no packet after the block has been heard yet.
+ */
+func (s *sender) fecPending(seqno int64, now time.Time) bool {
+	k := int64(s.fecBlockSize)
+
+	if !s.fecSeen || k <= 0 || diff32(seqno, s.fecNext) < 0 {
+		return false
+	}
+	if millis(now.Sub(s.lastTimeForData)) > maxFecDelay {
+		return false
+	}
+
+	/* the blocks follow each other, the last one heard gives the boundaries */
+
+	last := s.fecNext + (int64(diff32(seqno, s.fecNext))/k+1)*k - 1
+
+	return diff32(s.maxseq, last) <= 0
+}
@@ -0,0 +1,121 @@
+package lrmp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/**
+ * joins a sender protecting blocks of 4 packets and a receiver losing the
+ * given packets. Returns the sender, the receiver and its transport.
+ */
+func fecSession(n *testNet, r *recorder, drop ...string) (*lrmp.Lrmp, *lrmp.Lrmp, *payloadLoss) {
+	n.t.Helper()
+
+	p := n.profile()
+	p.FecBlockSize = 4
+	a := n.join("10.0.0.1", p)
+
+	transport := newPayloadLoss(n.group.Join(net.ParseIP("10.0.0.2")), drop...)
+
+	p = n.profile()
+	p.Handler = r
+	b := n.joinWith(transport, 1, p)
+
+	return a, b, transport
+}
+
+func TestFecRecoversSingleLoss(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	r := &recorder{}
+	a, b, transport := fecSession(n, r, "5")
+
+	sendPackets(t, a, 0, 12)
+
+	if !n.runUntil(10*time.Second, func() bool { return r.count() == 12 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 12)
+
+	if transport.count() != 1 {
+		t.Fatalf("dropped %d packets", transport.count())
+	}
+	if s := b.Stats(); s.FecRecovered != 1 {
+		t.Fatalf("recovered %d packets, want 1", s.FecRecovered)
+	}
+	if d := b.Domains()[0]; d.Nack != 0 {
+		t.Fatalf("sent %d NACKs for a loss rebuilt from the parity", d.Nack)
+	}
+}
+
+/* the parity is sent right after the last packet, before the gap is seen */
+func TestFecRecoversLastOfBlock(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	r := &recorder{}
+	a, b, _ := fecSession(n, r, "7")
+
+	sendPackets(t, a, 0, 12)
+
+	if !n.runUntil(10*time.Second, func() bool { return r.count() == 12 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 12)
+
+	if s := b.Stats(); s.FecRecovered != 1 {
+		t.Fatalf("recovered %d packets, want 1", s.FecRecovered)
+	}
+	if d := b.Domains()[0]; d.Nack != 0 {
+		t.Fatalf("sent %d NACKs for a loss rebuilt from the parity", d.Nack)
+	}
+}
+
+/* the parity of the tail of the transmission is sent once the sender is idle */
+func TestFecRecoversPartialBlock(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	r := &recorder{}
+	a, b, _ := fecSession(n, r, "5")
+
+	sendPackets(t, a, 0, 6)
+
+	if !n.runUntil(10*time.Second, func() bool { return r.count() == 6 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 6)
+
+	if s := b.Stats(); s.FecRecovered != 1 {
+		t.Fatalf("recovered %d packets, want 1", s.FecRecovered)
+	}
+	if s := a.Stats(); s.FecPackets != 2 {
+		t.Fatalf("sent %d FEC packets, want 2", s.FecPackets)
+	}
+}
+
+/*
+ * a single parity packet can't rebuild two losses, a NACK is sent. Once one
+ * is repaired the parity may rebuild the other.
+ */
+func TestFecFallsBackToNack(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	r := &recorder{}
+	a, b, _ := fecSession(n, r, "4", "5")
+
+	sendPackets(t, a, 0, 12)
+
+	if !n.runUntil(10*time.Second, func() bool { return r.count() == 12 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 12)
+
+	d := b.Domains()[0]
+
+	if d.Nack == 0 || d.RepairPackets == 0 {
+		t.Fatalf("two losses of a block not repaired by NACK: %+v", d)
+	}
+	if n := d.RepairPackets + b.Stats().FecRecovered; n < 2 {
+		t.Fatalf("%d of the 2 losses repaired", n)
+	}
+}
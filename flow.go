@@ -1,23 +1,55 @@
 package lrmp
 
-import "time"
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const rateHistorySize = 64
+
+/* number of checks with a nearly full send queue before ignoring receivers */
+const fullQueueChecks = 8
+
+/**
+ * RateChange records an adjustment of the transmission rate, rates are in
+ * bytes/sec.
+ */
+type RateChange struct {
+	Time time.Time
+	From int
+	To   int
+}
 
 type flow struct {
 	cxt         *Context
 	lastPackets int
 	lastBytes   int
 	lastTime    time.Time
+	lastAdjust  time.Time
+	history     []RateChange
+	fullChecks  int
+	/* packets in the send queue, not counting the wakeups and the mark */
+	queued atomic.Int32
+	/* closed when no more packets are accepted */
+	closing chan struct{}
+	/* queued behind the last packet, drained is closed once it is reached */
+	mark    *Packet
+	drained chan struct{}
+	done    chan struct{}
 }
 
 func newFlow(cxt *Context) *flow {
-	f := flow{cxt: cxt}
+	f := flow{cxt: cxt, closing: make(chan struct{}), mark: &Packet{}, drained: make(chan struct{}), done: make(chan struct{})}
 
 	go func() {
 		var didSend bool
 		for {
 			var pack *Packet
 
-			idleTime := f.cxt.sndInterval / 16
+			cxt.lock()
+			idleTime := cxt.sndInterval / 16
+			cxt.unlock()
 
 			if idleTime < 1000 {
 				idleTime = 1000
@@ -25,27 +57,43 @@ func newFlow(cxt *Context) *flow {
 				idleTime = 4000
 			}
 
+			idle, timer := newTimer(f.cxt.clock, time.Millisecond*time.Duration(idleTime))
+
 			select {
 			case pack = <-f.cxt.sendQueue:
 				break
-			case <-time.After(time.Millisecond * time.Duration(idleTime)):
+			case <-idle:
 				if didSend {
 					f.cxt.lrmp.idle()
 					didSend = false
 				}
 				break
+			case <-f.done:
+				timer.Stop()
+				return
 			}
 
+			timer.Stop()
+
 			f.resend() // always check resend
 
+			if pack == f.mark {
+				close(f.drained)
+				continue
+			}
+
 			if pack == nil { // might be wakeup from resend queue
 				continue
 			}
 
+			f.queued.Add(-1)
+
 			didSend = true
 
 			/* send a packet */
 
+			cxt.lock()
+
 			pack.source = cxt.whoami
 			pack.sender = pack.source
 
@@ -70,7 +118,14 @@ func newFlow(cxt *Context) *flow {
 
 			cxt.lrmp.sendDataPacket(pack, false)
 
+			if pack.reliable {
+				cxt.lrmp.fecEncode(pack)
+			}
+
 			f.flowControl()
+
+			cxt.unlock()
+
 			f.throttle()
 		}
 	}()
@@ -78,19 +133,126 @@ func newFlow(cxt *Context) *flow {
 	return &f
 }
 
-func (f *flow) enqueue(p *Packet) {
-	f.cxt.sendQueue <- p
+/**
+ * returns true once no more packets are accepted.
+ */
+func (f *flow) isClosing() bool {
+	select {
+	case <-f.closing:
+		return true
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *flow) enqueue(ctx context.Context, p *Packet) error {
+	/* a select picks at random among the ready cases, so check first */
+
+	if f.isClosing() {
+		return ErrClosed
+	}
+
+	f.queued.Add(1)
+
+	select {
+	case f.cxt.sendQueue <- p:
+		return nil
+	case <-ctx.Done():
+		f.queued.Add(-1)
+		return ctx.Err()
+	case <-f.closing:
+		f.queued.Add(-1)
+		return ErrClosed
+	case <-f.done:
+		f.queued.Add(-1)
+		return ErrClosed
+	}
+}
+
+func (f *flow) tryEnqueue(p *Packet) error {
+	if f.isClosing() {
+		return ErrClosed
+	}
+
+	f.queued.Add(1)
+
+	select {
+	case f.cxt.sendQueue <- p:
+		return nil
+	default:
+		f.queued.Add(-1)
+		return ErrQueueFull
+	}
+}
+
+/**
+ * returns the number of packets waiting to be sent. A packet is counted
+ * before it enters the queue, so the sends blocked on a full queue are not.
+ */
+func (f *flow) queueLen() int {
+	n := int(f.queued.Load())
+	if n > cap(f.cxt.sendQueue) {
+		n = cap(f.cxt.sendQueue)
+	}
+	return n
 }
 func (f *flow) flush() {
 }
 
+/**
+ * stops accepting packets and waits until the packets already queued have
+ * been sent. The resends go on. Returns ErrClosed if the sender is stopped
+ * first.
+ */
+func (f *flow) drain(ctx context.Context) error {
+	close(f.closing)
+
+	select {
+	case f.cxt.sendQueue <- f.mark:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+		return ErrClosed
+	}
+
+	select {
+	case <-f.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+		return ErrClosed
+	}
+}
+
+/**
+ * stops the sender goroutine, the packets still queued are dropped.
+ */
 func (f *flow) stop() {
-	close(f.cxt.sendQueue)
+	close(f.done)
 }
 
+/**
+ * waits for the send interval, must be called without the protocol lock.
+ */
 func (f *flow) throttle() {
-	if f.cxt.profile.Throughput != BestEffort && f.cxt.sndInterval > 0 {
-		time.Sleep(time.Duration(f.cxt.sndInterval) * time.Millisecond)
+	f.cxt.lock()
+	interval := f.cxt.sndInterval
+	if f.cxt.profile.Throughput == BestEffort {
+		interval = 0
+	}
+	f.cxt.unlock()
+
+	if interval > 0 {
+		wakeup, timer := newTimer(f.cxt.clock, time.Duration(interval)*time.Millisecond)
+
+		select {
+		case <-wakeup:
+		case <-f.done:
+			timer.Stop()
+		}
 	}
 }
 
@@ -101,6 +263,11 @@ func (f *flow) resend() {
 		if pack == nil {
 			break
 		}
+
+		/* the scope of a queued packet may be raised by a NACK */
+
+		f.cxt.lock()
+
 		if isDebug() {
 			logDebug("resending #", pack.seqno, " @", pack.scope)
 		}
@@ -109,12 +276,19 @@ func (f *flow) resend() {
 
 		f.cxt.lrmp.sendDataPacket(pack, true)
 
-		if !f.cxt.resendQueue.isEmpty() {
+		more := !f.cxt.resendQueue.isEmpty()
+
+		if more {
 			f.flowControl()
-			f.throttle()
-		} else {
+		}
+
+		f.cxt.unlock()
+
+		if !more {
 			break
 		}
+
+		f.throttle()
 	}
 }
 
@@ -129,13 +303,19 @@ func (f *flow) flowControl() {
 
 	f.lastPackets = cxt.whoami.packets
 
+	f.ageDecreases()
+
 	bcount := cxt.whoami.bytes - f.lastBytes
 
 	f.lastBytes = cxt.whoami.bytes
 
-	cur := time.Now()
+	cur := cxt.clock.Now()
 
-	cxt.actualRate = bcount * 1000 / int(millis(cur.Sub(f.lastTime)))
+	/* less than a millisecond with a high rate, keep the last measure */
+
+	if elapsed := int(millis(cur.Sub(f.lastTime))); elapsed > 0 {
+		cxt.actualRate = bcount * 1000 / elapsed
+	}
 
 	cxt.whoami.setRate(cxt.actualRate)
 
@@ -145,12 +325,46 @@ func (f *flow) flowControl() {
 		return
 	}
 
-	cxt.curRate = (cxt.curRate * cxt.adjust) >> 3
+	if f.queueLen() >= cap(cxt.sendQueue)*3/4 {
+		f.fullChecks++
+
+		if f.fullChecks >= fullQueueChecks && cxt.profile.IgnoreSlowReceivers {
+			f.ignoreSlowest()
+			f.fullChecks = 0
+		}
+	} else {
+		f.fullChecks = 0
+	}
+
+	/*
+	 * increase only if one eighth of the send window time has elapsed
+	 * since the last decrease or increase. The window time is computed from
+	 * the rate as the send interval is 0 for small packets at high rates.
+	 */
+	if cxt.adjust > None {
+		windowTime := ((cxt.profile.SendWindowSize * bcount * 1000 / pcount) / cxt.curRate) >> 3
 
-	if cxt.curRate < cxt.profile.minRate {
-		cxt.curRate = cxt.profile.minRate
-	} else if cxt.curRate > cxt.profile.maxRate {
-		cxt.curRate = cxt.profile.maxRate
+		if int(millis(cur.Sub(f.lastAdjust))) < windowTime {
+			cxt.adjust = None
+		}
+	}
+
+	if cxt.adjust != None {
+		rate := (cxt.curRate * cxt.adjust) >> 3
+
+		if rate < cxt.minRate {
+			rate = cxt.minRate
+		} else if rate > cxt.maxRate {
+			rate = cxt.maxRate
+		}
+
+		f.lastAdjust = cur
+
+		if rate != cxt.curRate {
+			f.addRateChange(cur, rate)
+		}
+
+		cxt.curRate = rate
 	}
 
 	cxt.adjust = SmallIncrease
@@ -172,6 +386,89 @@ func (f *flow) flowControl() {
 	}
 }
 
+/**
+ * applies the rate adjustment caused by a loss or congestion report from the
+ * reporter. The largest decrease until the next check wins, and the reports
+ * of ignored receivers have no effect.
+ */
+func (f *flow) adjustRate(reporter Entity, adjust int) {
+	/* counted for ignored receivers too, to know when they recover */
+
+	if adjust < None {
+		reporter.incDecrease()
+	}
+
+	if reporter.isIgnored() {
+		if isDebug() {
+			logDebug("ignored rate adjustment from ", reporter)
+		}
+		return
+	}
+
+	if adjust < f.cxt.adjust {
+		f.cxt.adjust = adjust
+	}
+}
+
+/**
+ * the send queue stays full, i.e. the rate is kept low by the receivers.
+ * Ignores further congestion signals from the receiver which caused most of
+ * the rate decreases (draft section 7.3).
+ */
+func (f *flow) ignoreSlowest() {
+	var slowest Entity
+
+	decreases := 0
+
+	for _, e := range f.cxt.sm.entities {
+		if e == f.cxt.whoami || e.isIgnored() {
+			continue
+		}
+
+		decreases += e.getRecentDecreases()
+
+		if slowest == nil || e.getRecentDecreases() > slowest.getRecentDecreases() {
+			slowest = e
+		}
+	}
+
+	/* only if the receiver is responsible for the majority of decreases */
+
+	if slowest != nil && slowest.getRecentDecreases()*2 > decreases {
+		slowest.setIgnored(true)
+
+		if isDebug() {
+			logDebug("ignoring congestion signals from slow receiver ", slowest)
+		}
+	}
+}
+
+/**
+ * ages the rate decreases caused by the receivers at each check, so that an
+ * ignored receiver which no longer causes decreases is heard again.
+ */
+func (f *flow) ageDecreases() {
+	for _, e := range f.cxt.sm.entities {
+		e.ageDecreases()
+
+		if e.isIgnored() && e.getRecentDecreases() == 0 {
+			e.setIgnored(false)
+
+			if isDebug() {
+				logDebug("hearing congestion signals again from receiver ", e)
+			}
+		}
+	}
+}
+
+func (f *flow) addRateChange(now time.Time, rate int) {
+	if len(f.history) >= rateHistorySize {
+		f.history = f.history[1:]
+	}
+
+	f.history = append(f.history, RateChange{Time: now, From: f.cxt.curRate, To: rate})
+}
+
 func (f *flow) enqueueResend(pack *Packet, scope int) {
 	if f.cxt.resendQueue.contains(pack) {
 		if pack.scope < scope {
@@ -188,7 +485,12 @@ func (f *flow) enqueueResend(pack *Packet, scope int) {
 
 	f.cxt.resendQueue.enqueue(pack)
 
-	f.cxt.sendQueue <- nil
+	/* wake up the sender, called with the lock held so never block */
+
+	select {
+	case f.cxt.sendQueue <- nil:
+	default:
+	}
 }
 
 func (f *flow) cancelResend(s *sender, seqno int64, scope int) {
@@ -0,0 +1,174 @@
+package lrmp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/* a burst sent at once measures the rate over less than a millisecond */
+func TestBestEffortBurstOnVirtualClock(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	p := n.profile()
+	p.Throughput = lrmp.BestEffort
+
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 200)
+
+	if !n.runUntil(10*time.Second, func() bool { return r.count() == 200 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 200)
+}
+
+/* small packets at a high rate make the send interval 0 */
+func TestHighRateOnSystemClock(t *testing.T) {
+	g := vnet.NewGroup(1)
+
+	p := lrmp.NewProfile()
+	p.MinRate = 1000
+	p.MaxRate = 2000
+
+	a, err := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.1")), 1, *p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Start()
+	defer a.Stop()
+
+	r := &recorder{}
+	p = lrmp.NewProfile()
+	p.Handler = r
+
+	b, err := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.2")), 1, *p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Start()
+	defer b.Stop()
+
+	sendPackets(t, a, 0, 500)
+
+	for deadline := time.Now().Add(10 * time.Second); r.count() < 500 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkInOrder(t, r, 500)
+}
+
+/**
+ * returns the slow receiver entry of the receiver at addr.
+ */
+func slowReceiver(l *lrmp.Lrmp, addr string) lrmp.SlowReceiver {
+	for _, s := range l.SlowReceivers() {
+		if s.Addr.String() == addr {
+			return s
+		}
+	}
+	return lrmp.SlowReceiver{}
+}
+
+/*
+ * a receiver behind a lossy link keeps the send queue full, it is ignored
+ * and heard again once its link is repaired.
+ */
+func TestSlowReceiverIgnoredAndHeardAgain(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	p := n.profile()
+	p.IgnoreSlowReceivers = true
+	p.MinRate = 8
+	p.MaxRate = 64
+	a := n.join("10.0.0.1", p)
+
+	n.join("10.0.0.2", n.profile())
+	n.join("10.0.0.3", n.profile())
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{Loss: 0.3})
+
+	/* keeps the send queue full until the session is stopped */
+
+	go func() {
+		for a.Send(newPacket("x")) == nil {
+		}
+	}()
+
+	ignored := func() bool { return slowReceiver(a, "10.0.0.2").Ignored }
+
+	if !n.runUntil(10*time.Minute, ignored) {
+		t.Fatalf("slow receiver not ignored: %+v", a.SlowReceivers())
+	}
+	if slowReceiver(a, "10.0.0.3").Ignored {
+		t.Fatal("receiver without loss ignored")
+	}
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{})
+
+	if !n.runUntil(10*time.Minute, func() bool { return !ignored() }) {
+		t.Fatalf("repaired receiver still ignored: %+v", a.SlowReceivers())
+	}
+
+	/* it no longer slows down the sender */
+
+	for i := 0; i < 100; i++ {
+		n.run(50 * time.Millisecond)
+
+		if ignored() {
+			t.Fatalf("repaired receiver ignored again after %d ms", i*50)
+		}
+	}
+}
+
+/*
+ * the packets after a gap which is not repaired fill more than half the
+ * receive window, the receiver reports are flagged and halve the rate. The
+ * NACKs are lost so that the flag is the only congestion signal.
+ */
+func TestCongestionFlagHalvesRate(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+
+	p := n.profile()
+	p.RcvReportSelInterval = 1000
+	a := n.join("10.0.0.1", p)
+
+	transport := newPayloadLoss(n.group.Join(net.ParseIP("10.0.0.2")), "1")
+	transport.repairs = true
+	transport.nacks = true
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	p.RcvWindowSize = 32
+	n.joinWith(transport, 1, p)
+
+	sendPackets(t, a, 0, 20)
+
+	if !n.runUntil(30*time.Second, func() bool { return a.Stats().CongestionIndications > 0 }) {
+		t.Fatalf("no congestion indication, received %d packets", r.count())
+	}
+
+	/* the decrease is applied at the next rate check */
+
+	sendPackets(t, a, 20, 20)
+	n.run(10 * time.Second)
+
+	var decrease *lrmp.RateChange
+	for _, c := range a.RateHistory() {
+		if c.To < c.From {
+			decrease = &c
+			break
+		}
+	}
+	if decrease == nil || decrease.To > decrease.From/2+1 {
+		t.Fatalf("rate not halved: %+v", a.RateHistory())
+	}
+}
@@ -1,10 +1,9 @@
 package lrmp
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"golang.org/x/net/ipv4"
 	"math/rand"
 	"net"
 	"strconv"
@@ -12,14 +11,18 @@ import (
 )
 
 type impl struct {
-	stats       Stats
-	domainStats DomainStats
-	cxt         *Context
-	idleTime    int64
-	session     *msession
-	ttl         int
-	reports     map[Entity]*sender
-	task        *timerTask
+	cxt      *Context
+	idleTime int64
+	session  *msession
+	ttl      int
+	reports  map[Entity]*sender
+	jitters  map[Entity]int
+	task     *timerTask
+	fec      fecEncoder
+	/* no more packets are accepted */
+	closed bool
+	/* the session is released */
+	stopped bool
 }
 
 const maxPacketSize = MTU
@@ -38,61 +41,38 @@ const (
 	SR_PT     = 19
 	RS_PT     = 20
 	RR_PT     = 21
+	RJ_PT     = 22 /* jitter report, an extension of the draft */
+	BYE_PT    = 23
 )
 
-func newImpl(addr string, port int, ttl int, network string, profile Profile) (*impl, error) {
+func newImpl(transport Transport, ttl int, profile Profile) (*impl, error) {
 
-	group, err := net.ResolveUDPAddr("udp", addr+":"+strconv.Itoa(port))
-	if err != nil {
-		return nil, err
-	}
-
-	ifi, err := net.InterfaceByName(network)
-	if err != nil {
-		return nil, err
-	}
-
-	addrs, err := ifi.Addrs()
-	if err != nil {
-		return nil, err
-	}
-
-	var laddr net.IP
-
-	for _, a := range addrs {
-		if _, ok := a.(*net.IPNet); ok {
-			laddr = a.(*net.IPNet).IP.To4()
-			if laddr != nil {
-				break
-			}
-		}
-	}
+	laddr := transport.LocalAddr()
 
 	if laddr == nil {
-		return nil, errors.New("interface does not have IP address")
+		return nil, errors.New("transport does not have IP address")
 	}
 
-	l, err := net.ListenMulticastUDP("udp4", ifi, group)
-	if err != nil {
+	if err := checkTTL(transport, ttl); err != nil {
 		return nil, err
 	}
 
-	socket := ipv4.NewPacketConn(l)
-	err = socket.SetMulticastInterface(ifi)
-	if err != nil {
-		return nil, err
-	}
-	err = socket.SetMulticastLoopback(true)
-	if err != nil {
-		return nil, err
+	clock := profile.Clock
+	if clock == nil {
+		clock = SystemClock
 	}
 
-	cxt := newContext(laddr, ttl)
+	cxt := newContext(laddr, ttl, clock)
 
 	impl := impl{ttl: ttl, cxt: cxt}
 	impl.reports = make(map[Entity]*sender)
+	impl.jitters = make(map[Entity]int)
+
+	impl.session = newSession(transport, &impl)
+
+	/* the sender is already running */
 
-	impl.session = newSession(socket, &impl, group)
+	impl.cxt.mu.Lock()
 
 	impl.cxt.whoami = impl.cxt.sm.whoami
 
@@ -100,18 +80,130 @@ func newImpl(addr string, port int, ttl int, network string, profile Profile) (*
 
 	impl.cxt.lrmp = &impl
 
+	impl.cxt.mu.Unlock()
+
 	return &impl, nil
 }
 
 func (i *impl) startSession() {
 	i.session.start()
 
+	i.cxt.mu.Lock()
+	i.cxt.evict.start()
+	i.cxt.mu.Unlock()
+
 	if i.cxt.recover == nil {
 		i.initRecovery()
 	}
 }
+
+/**
+ * releases the session at once: the timers, the sender and the reader are
+ * stopped and the transport is closed.
+ */
 func (i *impl) stopSession() {
-	i.session.start()
+	i.cxt.mu.Lock()
+
+	if i.stopped {
+		i.cxt.mu.Unlock()
+		return
+	}
+
+	i.closed = true
+	i.stopped = true
+
+	if i.task != nil {
+		i.cxt.timer.recallTimer(i.task)
+		i.task = nil
+	}
+
+	i.cxt.recover.stop()
+	i.cxt.evict.stop()
+
+	i.cxt.mu.Unlock()
+
+	i.cxt.sender.stop()
+	i.cxt.timer.stop()
+	i.session.stop()
+}
+
+/**
+ * leaves the session gracefully. The packets queued are sent, then the
+ * NACKs are answered from the send window during the linger time, and the
+ * session is stopped. The session is stopped anyway when the context is
+ * done.
+ */
+func (i *impl) close(ctx context.Context) error {
+	i.cxt.mu.Lock()
+
+	if i.closed {
+		i.cxt.mu.Unlock()
+		return ErrClosed
+	}
+
+	i.closed = true
+
+	linger := i.cxt.profile.LingerTime
+	sender := !i.cxt.whoami.lastTimeForData.IsZero()
+
+	i.cxt.mu.Unlock()
+
+	err := i.cxt.sender.drain(ctx)
+
+	if err == nil && sender {
+
+		/* let the receivers know the last packet and recover it */
+
+		i.finalReport(true)
+
+		wakeup, timer := newTimer(i.cxt.clock, time.Duration(linger)*time.Millisecond)
+
+		select {
+		case <-wakeup:
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		}
+
+		/*
+		 * the BYE is repeated in case the first one is lost, but not the
+		 * sender report which would make the receivers which have already
+		 * removed the sender join it again.
+		 */
+		i.finalReport(false)
+	} else {
+		i.finalReport(true)
+	}
+
+	/* the losses still being extended are reported */
+
+	i.cxt.lock()
+	i.flushLosses()
+	i.cxt.unlock()
+
+	i.stopSession()
+
+	return err
+}
+
+/**
+ * sends the BYE, preceded by a sender report with the final sequence
+ * number if report is true.
+ */
+func (i *impl) finalReport(report bool) {
+	i.cxt.lock()
+	defer i.cxt.unlock()
+
+	if i.stopped {
+		return
+	}
+
+	if report && !i.cxt.whoami.lastTimeForData.IsZero() {
+		i.fecFlush()
+		i.sendSenderReport()
+	}
+
+	i.sendBye()
 }
 
 func (i *impl) initRecovery() {
@@ -122,34 +214,89 @@ func (i *impl) initRecovery() {
 	i.cxt.recover = newRecovery(i.ttl, i.cxt)
 }
 
+/**
+ * forgets the other entities and the pending recovery, so that the
+ * reception restarts from scratch, e.g. when leaving the session. The
+ * entities leave as if they were silent.
+ */
+func (i *impl) resetReception() {
+	for _, e := range i.cxt.sm.entities {
+		i.cxt.sm.leave(e)
+	}
+
+	i.reports = make(map[Entity]*sender)
+	i.jitters = make(map[Entity]int)
+
+	i.initRecovery()
+}
+
 func (i *impl) whoAmI() Entity {
 	return i.cxt.whoami
 }
 func (i *impl) send(pack *Packet) error {
+	return i.sendContext(context.Background(), pack)
+}
+
+/**
+ * queues the packet, waiting for room in the send queue until the context
+ * is done.
+ */
+func (i *impl) sendContext(ctx context.Context, pack *Packet) error {
+	if err := i.prepareSend(pack); err != nil {
+		return err
+	}
+
+	/* may block on a full queue, so outside of the lock */
+
+	return i.cxt.sender.enqueue(ctx, pack)
+}
+
+/**
+ * queues the packet if there is room in the send queue.
+ */
+func (i *impl) trySend(pack *Packet) error {
+	if err := i.prepareSend(pack); err != nil {
+		return err
+	}
+
+	return i.cxt.sender.tryEnqueue(pack)
+}
+
+func (i *impl) prepareSend(pack *Packet) error {
+	i.cxt.mu.Lock()
+
+	if i.closed {
+		i.cxt.mu.Unlock()
+		return ErrClosed
+	}
+
 	if pack.reliable && i.cxt.whoami.lastTimeForData.IsZero() {
 		i.sendSenderReport()
-		i.cxt.whoami.initCache(i.cxt.profile.sendWindowSize)
-		i.cxt.whoami.lastTimeForData = time.Now()
+		i.cxt.whoami.initCache(i.cxt.profile.SendWindowSize)
+		i.cxt.whoami.lastTimeForData = i.cxt.clock.Now()
 	}
 
-	i.cxt.sender.enqueue(pack)
-
 	if i.idleTime > 0 {
 		i.idleTime = 0
-		i.cxt.whoami.nextSRTime = time.Now().Add(time.Duration(i.cxt.senderReportInterval))
+		i.cxt.whoami.nextSRTime = addMillis(i.cxt.clock.Now(), i.cxt.profile.SenderReportInterval)
 
-		i.startTimer(i.cxt.senderReportInterval)
+		i.startTimer(i.cxt.profile.SenderReportInterval)
 	}
+
+	i.cxt.mu.Unlock()
+
 	return nil
 }
 
 func (i *impl) idle() {
+	i.cxt.lock()
+	defer i.cxt.unlock()
 
 	if isDebug() {
 		logDebug("idle()")
 	}
 
-	now := time.Now()
+	now := i.cxt.clock.Now()
 	idleTime := i.cxt.sndInterval / 16
 
 	if idleTime < 1000 {
@@ -159,31 +306,35 @@ func (i *impl) idle() {
 	}
 	i.cxt.whoami.nextSRTime = addMillis(now, idleTime)
 	i.startTimer(idleTime)
+
+	/* protect the tail of the transmission */
+
+	i.fecFlush()
 }
 
 func (i *impl) startTimer(millis int) {
-	t1 := addMillis(time.Now(), millis)
+	t1 := addMillis(i.cxt.clock.Now(), millis)
 
 	if i.task != nil {
 		if t1.After(i.task.time) {
 			return
 		}
-		timer.recallTimer(i.task)
+		i.cxt.timer.recallTimer(i.task)
 	}
 	if isDebug() {
 		logDebug("next timeout in ", millis)
 	}
 
-	i.task = timer.registerTimer(millis, i, nil)
+	i.task = i.cxt.timer.registerTimer(millis, i, nil)
 }
 
 func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
-	i.task = nil
+	i.cxt.lock()
+	defer i.cxt.unlock()
 
-	p := NewPacket(false, 1024)
+	i.task = nil
 
-	p.scope = i.ttl
-	p.offset = 0
+	p := i.newControlPacket()
 
 	timeout := checkInterval
 
@@ -197,13 +348,13 @@ func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
 	 * send several sender reports when the transmission is stopped.
 	 */
 	if cxt.whoami.expected != cxt.whoami.startseq {
-		if millis(thetime.Sub(cxt.whoami.lastTimeForData)) < sndDropTime {
+		if millis(thetime.Sub(cxt.whoami.lastTimeForData)) < int64(cxt.profile.SndDropTime) {
 			diff := int(millis(cxt.whoami.nextSRTime.Sub(thetime)))
 
 			if diff <= 0 {
-				p.appendSenderReport(cxt.whoami)
+				p.appendSenderReport(cxt.whoami, thetime)
 
-				cxt.stats.senderReports++
+				cxt.stats.SenderReports++
 
 				/* update rate */
 
@@ -228,7 +379,7 @@ func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
 						timeout = 2000
 					}
 				} else {
-					timeout = cxt.senderReportInterval
+					timeout = cxt.profile.SenderReportInterval
 				}
 
 				cxt.whoami.nextSRTime = addMillis(thetime, timeout)
@@ -236,14 +387,14 @@ func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
 				timeout = diff
 			}
 
-			if timeout > cxt.rcvReportSelInterval {
-				timeout = cxt.rcvReportSelInterval
+			if timeout > cxt.profile.RcvReportSelInterval {
+				timeout = cxt.profile.RcvReportSelInterval
 			}
 
-			if cxt.profile.rcvReportSelection != NoReceiverReport && int(millis(thetime.Sub(cxt.whoami.rrSelectTime))) > cxt.rcvReportSelInterval {
+			if cxt.profile.RcvReportSelection != NoReceiverReport && int(millis(thetime.Sub(cxt.whoami.rrSelectTime))) > cxt.profile.RcvReportSelInterval {
 
-				if cxt.stats.populationEstimate < cxt.sm.getNumberOfEntities() {
-					cxt.stats.populationEstimate = cxt.sm.getNumberOfEntities()
+				if cxt.stats.PopulationEstimate < cxt.sm.getNumberOfEntities() {
+					cxt.stats.PopulationEstimate = cxt.sm.getNumberOfEntities()
 				}
 
 				cxt.whoami.rrInterval = 10 /* seconds */
@@ -252,18 +403,18 @@ func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
 				 * limit the number of reports to 100, so using the following
 				 * formula probability*population < 100.
 				 */
-				cxt.whoami.rrProb = (100 << 16) / (cxt.stats.populationEstimate + 1)
+				cxt.whoami.rrProb = (100 << 16) / (cxt.stats.PopulationEstimate + 1)
 
 				if cxt.whoami.rrProb > 0xffff {
 					cxt.whoami.rrProb = 0xffff
 				}
 
-				p.appendRRSelection(cxt.whoami, cxt.whoami.rrProb, cxt.whoami.rrInterval)
+				p.appendRRSelection(cxt.whoami, cxt.whoami.rrProb, cxt.whoami.rrInterval, thetime)
 
-				cxt.whoami.rrSelectTime = time.Now()
+				cxt.whoami.rrSelectTime = thetime
 				cxt.whoami.rrReplies = 0
-				cxt.stats.populationEstimate = 0
-				cxt.stats.rrSelect++
+				cxt.stats.PopulationEstimate = 0
+				cxt.stats.RrSelect++
 			}
 		}
 		if isDebug() {
@@ -276,12 +427,28 @@ func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
 	 */
 	for e, s := range i.reports {
 
-		delay := int(s.nextRRTime.Sub(thetime))
+		delay := int(millis(s.nextRRTime.Sub(thetime)))
 
 		if delay <= 0 {
-			p.appendReceiverReport(s, cxt.whoami)
 
-			cxt.stats.receiverReports++
+			/* the reports of many senders take several packets */
+
+			length := rrLength
+			if cxt.profile.JitterReports {
+				length += rjLength
+			}
+			if p.room() < length {
+				i.sendControlPacket(p, i.ttl)
+				p = i.newControlPacket()
+			}
+
+			p.appendReceiverReport(s, cxt.whoami, thetime)
+
+			if cxt.profile.JitterReports {
+				p.appendJitterReport(s, cxt.whoami)
+			}
+
+			cxt.stats.ReceiverReports++
 
 			if s.rrProb > 0 { /* once */
 				delete(i.reports, e)
@@ -300,17 +467,32 @@ func (i *impl) handleTimerTask(data interface{}, thetime time.Time) {
 	}
 
 	/* prune the list of entities heard */
-	cxt.sm.prune(rcvDropTime)
+	cxt.sm.prune(int64(cxt.profile.RcvDropTime))
+
+	for e := range i.jitters {
+		if cxt.sm.get(e.getID()) != e {
+			delete(i.jitters, e)
+		}
+	}
 	i.startTimer(timeout)
 }
 
+func (i *impl) newControlPacket() *Packet {
+	p := NewPacket(false, 1024)
+
+	p.scope = i.ttl
+	p.offset = 0
+
+	return p
+}
+
 func (i *impl) sendControlPacket(pack *Packet, ttl int) {
 	cxt := i.cxt
 
-	cxt.whoami.setLastTimeHeard(time.Now())
+	cxt.whoami.setLastTimeHeard(cxt.clock.Now())
 
-	cxt.stats.ctrlPackets++
-	cxt.stats.ctrlBytes += int64(pack.offset)
+	cxt.stats.CtrlPackets++
+	cxt.stats.CtrlBytes += int64(pack.offset)
 
 	i.session.send(pack.buff, pack.offset, ttl)
 }
@@ -319,12 +501,33 @@ func (i *impl) flush() {
 	i.cxt.sender.flush()
 }
 
-func (i *impl) setTTL(ttl int) {
-	i.ttl = ttl
-	if i.cxt.recover != nil {
-		i.cxt.recover.stop()
+/**
+ * changes the TTL and rebuilds the recovery domains for the new scope. The
+ * losses being recovered are detected again with the next packets.
+ */
+func (i *impl) setTTL(ttl int) error {
+	if err := checkTTL(i.session.socket, ttl); err != nil {
+		return err
 	}
+
+	i.ttl = ttl
 	i.initRecovery()
+
+	return nil
+}
+
+/**
+ * checks that the TTL fits in the scope field of the packets, and that the
+ * group address does not limit the scope further.
+ */
+func checkTTL(transport Transport, ttl int) error {
+	if ttl < 0 || ttl > 255 {
+		return errors.New("invalid ttl " + strconv.Itoa(ttl))
+	}
+	if st, ok := transport.(ScopedTransport); ok && ttl > st.MaxScope() {
+		return errors.New("ttl " + strconv.Itoa(ttl) + " exceeds the scope " + strconv.Itoa(st.MaxScope()) + " of the group")
+	}
+	return nil
 }
 
 /**
@@ -355,10 +558,26 @@ func (i *impl) sendSenderReport() {
 	p.scope = i.ttl
 	p.offset = 0
 
-	p.appendSenderReport(i.cxt.whoami)
+	p.appendSenderReport(i.cxt.whoami, i.cxt.clock.Now())
 	i.sendControlPacket(p, i.ttl)
 
-	i.cxt.stats.senderReports++
+	i.cxt.stats.SenderReports++
+}
+
+/**
+ * processes a packet received from the session.
+ */
+func (i *impl) receive(buff []byte, totalLen int, ip net.IP) {
+	i.cxt.lock()
+	defer i.cxt.unlock()
+
+	/* the session was left on high loss, or is stopped */
+
+	if i.cxt.evict.isOut() || i.stopped {
+		return
+	}
+
+	i.parse(buff, totalLen, ip)
 }
 
 func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
@@ -369,7 +588,7 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 	 * validity check.
 	 */
 	if totalLen < 12 {
-		cxt.stats.badLength++
+		cxt.stats.BadLength++
 
 		if isDebug() {
 			logDebug("packet too short (" + strconv.Itoa(totalLen) + ") " + ip.String())
@@ -381,7 +600,7 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 	v := int(buff[0]&0xff) >> 6
 
 	if v != VersionNumber {
-		cxt.stats.badVersion++
+		cxt.stats.BadVersion++
 
 		if isDebug() {
 			logDebug("incorrect version (" + strconv.Itoa(v) + ") " + ip.String())
@@ -395,13 +614,21 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 
 	/* ignore loopback packets */
 
-	if i.cxt.whoami.getID() == id && bytes.Equal(i.cxt.whoami.getAddress(), ip) {
+	if i.cxt.whoami.getID() == id && i.cxt.whoami.getAddress().Equal(ip) {
 		if isTrace() {
 			logTrace("ignoring packet from me")
 		}
 		return
 	}
 
+	/*
+	 * a BYE from an unknown entity, e.g. a repeated one, or any packet from
+	 * an entity which has said BYE.
+	 */
+	if (int(buff[0]&0x1f) == BYE_PT && cxt.sm.get(id) == nil) || cxt.sm.hasGone(id) {
+		return
+	}
+
 	s := cxt.sm.lookup(id, ip)
 
 	if s == nil {
@@ -430,7 +657,7 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 		len := int(byteToShort(buff, offset+2))
 
 		if len < 12 || (len+offset) > totalLen {
-			cxt.stats.badLength++
+			cxt.stats.BadLength++
 
 			logError("bad packet length " + strconv.Itoa(len))
 
@@ -442,8 +669,8 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 		t := int(buff[offset] & 0x1f)
 
 		if t >= 16 {
-			cxt.stats.ctrlPackets++
-			cxt.stats.ctrlBytes += int64(len)
+			cxt.stats.CtrlPackets++
+			cxt.stats.CtrlBytes += int64(len)
 
 			switch t {
 
@@ -467,13 +694,21 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 				i.processReceiverReport(s, buff, offset, len)
 				break
 
+			case RJ_PT:
+				i.processJitterReport(s, buff, offset, len)
+				break
+
+			case BYE_PT:
+				i.processBye(s, buff, offset, len)
+				break
+
 			default:
 				logError("bad control pt " + strconv.Itoa(t))
 				break
 			}
 		} else {
-			cxt.stats.dataPackets++
-			cxt.stats.dataBytes += int64(len)
+			cxt.stats.DataPackets++
+			cxt.stats.DataBytes += int64(len)
 
 			b := make([]byte, totalLen)
 			copy(b, buff)
@@ -484,7 +719,7 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 				i.processRepairData(s, b, offset, len)
 			} else if t >= U_DATA_PT && t < F_DATA_PT {
 				i.processUnreliableData(s, b, offset, len)
-			} else if t == R_DATA_PT {
+			} else if t >= F_DATA_PT {
 				i.processFecData(s, b, offset, len)
 			} else {
 				logError("bad data pt " + strconv.Itoa(t))
@@ -494,7 +729,7 @@ func (i *impl) parse(buff []byte, totalLen int, ip net.IP) {
 		offset += len
 	}
 
-	s.setLastTimeHeard(time.Now())
+	s.setLastTimeHeard(cxt.clock.Now())
 }
 
 func (i *impl) processNack(s Entity, buff []byte, offset int, len int) {
@@ -517,6 +752,7 @@ func (i *impl) processNack(s Entity, buff []byte, offset int, len int) {
 		e := i.cxt.sm.get(src)
 
 		if _, isSender := e.(*sender); !isSender {
+			offset += 12
 			continue
 		}
 
@@ -524,7 +760,7 @@ func (i *impl) processNack(s Entity, buff []byte, offset int, len int) {
 
 		ev := newLossEvent(e.(*sender))
 
-		ev.rcvSendTime = time.Now()
+		ev.rcvSendTime = cxt.clock.Now()
 		ev.low = int64(byteToInt(buff, offset))
 		offset += 4
 		ev.bitmask = uint32(byteToInt(buff, offset))
@@ -545,19 +781,19 @@ func (i *impl) processNack(s Entity, buff []byte, offset int, len int) {
 			k := int(cxt.whoami.expected - int64(ev.low))
 
 			if k > (cxt.whoami.cacheSize >> 1) {
-				cxt.adjust = BigDecrease
+				cxt.sender.adjustRate(s, BigDecrease)
 			} else if k > (cxt.whoami.cacheSize / 3) {
-				cxt.adjust = MediumDecrease
+				cxt.sender.adjustRate(s, MediumDecrease)
 			} else if k > (cxt.whoami.cacheSize >> 2) {
-				cxt.adjust = SmallDecrease
+				cxt.sender.adjustRate(s, SmallDecrease)
 			} else {
-				cxt.adjust = None
+				cxt.sender.adjustRate(s, None)
 			}
 		}
 	}
 
 	if len > 0 {
-		cxt.stats.badLength++
+		cxt.stats.BadLength++
 	} else {
 		s.incNack()
 	}
@@ -597,7 +833,7 @@ func (i *impl) processNackReply(s Entity, buff []byte, offset int, len int) {
 
 		ev := newLossEvent(e.(*sender))
 
-		ev.rcvSendTime = time.Now()
+		ev.rcvSendTime = cxt.clock.Now()
 		ev.reporter = cxt.sm.get(to)
 
 		if ev.reporter == nil {
@@ -617,7 +853,7 @@ func (i *impl) processNackReply(s Entity, buff []byte, offset int, len int) {
 	}
 
 	if len > 0 {
-		cxt.stats.badLength++
+		cxt.stats.BadLength++
 	}
 }
 
@@ -640,7 +876,7 @@ func (i *impl) processSenderReport(e Entity, buff []byte, offset int, len int) {
 		s = e.(*sender)
 	} else {
 		s = cxt.sm.lookupSender(e.getID(), e.getAddress(), seqno)
-		s.setRate((cxt.profile.minRate + cxt.profile.maxRate) / 2)
+		s.setRate((cxt.minRate + cxt.maxRate) / 2)
 	}
 
 	s.srSeqno = seqno
@@ -692,13 +928,13 @@ func (i *impl) processSenderReport(e Entity, buff []byte, offset int, len int) {
 	s.srTimestamp = timestamp
 	s.srPackets = packets
 	s.srBytes = bytes
-	cxt.stats.senderReports++
+	cxt.stats.SenderReports++
 }
 
 func (i *impl) processRRSelection(e Entity, buff []byte, offset int, len int) {
 	cxt := i.cxt
 
-	cxt.stats.rrSelect++
+	cxt.stats.RrSelect++
 
 	if _, isSender := e.(*sender); !isSender {
 
@@ -728,7 +964,7 @@ func (i *impl) processRRSelection(e Entity, buff []byte, offset int, len int) {
 		id := uint32(byteToInt(buff, offset))
 
 		if id == broadcastSrc || id == cxt.whoami.getID() {
-			s.rrSelectTime = time.Now()
+			s.rrSelectTime = cxt.clock.Now()
 			s.rrReplies = 0
 
 			send := true
@@ -746,7 +982,7 @@ func (i *impl) processRRSelection(e Entity, buff []byte, offset int, len int) {
 				logDebug("RR select prob=", s.rrProb, " interv=", s.rrInterval, " ", send)
 			}
 			if send {
-				now := time.Now()
+				now := cxt.clock.Now()
 
 				delay := i.randomize(s.rrInterval)
 
@@ -754,7 +990,7 @@ func (i *impl) processRRSelection(e Entity, buff []byte, offset int, len int) {
 					break // if we keep rescheduling we never send
 				}
 
-				s.nextRRTime = time.Now().Add(time.Duration(delay) * time.Millisecond)
+				s.nextRRTime = addMillis(now, delay)
 
 				i.startTimer(delay)
 
@@ -781,12 +1017,12 @@ func (i *impl) processReceiverReport(e Entity, buff []byte, offset int, len int)
 	offset += 8
 	len -= 8
 
-	now := time.Now()
-
 	cxt := i.cxt
 
+	now := cxt.clock.Now()
+
 	for len >= 20 {
-		cxt.stats.receiverReports++
+		cxt.stats.ReceiverReports++
 
 		to := uint32(byteToInt(buff, offset))
 
@@ -808,13 +1044,13 @@ func (i *impl) processReceiverReport(e Entity, buff []byte, offset int, len int)
 				/* suppose the estimation scheme is not changed */
 
 				if sender.rrProb > 0 {
-					cxt.stats.populationEstimate = (sender.rrReplies<<16)/sender.rrProb + 1
-					cxt.stats.populationEstimateTime = now
+					cxt.stats.PopulationEstimate = (sender.rrReplies<<16)/sender.rrProb + 1
+					cxt.stats.PopulationEstimateTime = now
 				}
 			} else {
 				if sender != cxt.whoami {
 					sender.rrReplies = 0
-					cxt.stats.populationEstimate = 0
+					cxt.stats.PopulationEstimate = 0
 				}
 			}
 			if s == cxt.whoami {
@@ -822,7 +1058,7 @@ func (i *impl) processReceiverReport(e Entity, buff []byte, offset int, len int)
 
 				/* NTP offset is subtracted */
 
-				rtt := ntp32(now.UnixNano()/int64(time.Millisecond)) - timestamp - delay
+				rtt := ntp32(unixMillis(now)) - timestamp - delay
 
 				rtt = fixedPoint32ToMillis(rtt)
 
@@ -835,11 +1071,23 @@ func (i *impl) processReceiverReport(e Entity, buff []byte, offset int, len int)
 						d.updateMRTT(rtt)
 					}
 				} else {
-					logError("bad rtt ", rtt, " ", e, " ", ntp32(nowMillis()), "/", delay, "/", timestamp)
+					logError("bad rtt ", rtt, " ", e, " ", ntp32(unixMillis(now)), "/", delay, "/", timestamp)
 				}
 				if isDebug() {
 					logDebug("RR from ", e, " rtt=", rtt)
 				}
+
+				/* the receiver is congested, halve the rate */
+
+				if buff[offset+8]&0x80 != 0 {
+					cxt.stats.CongestionIndications++
+
+					cxt.sender.adjustRate(e, MediumDecrease)
+
+					if isDebug() {
+						logDebug("congestion indication from ", e)
+					}
+				}
 			}
 
 			/* other field ignored */
@@ -853,6 +1101,89 @@ func (i *impl) processReceiverReport(e Entity, buff []byte, offset int, len int)
 	}
 }
 
+/**
+ * processes a BYE packet, the entity leaves the session. The data of a
+ * sender is still recovered up to the final sequence number.
+ */
+func (i *impl) processBye(e Entity, buff []byte, offset int, len int) {
+	seqno := int64(byteToInt(buff, offset+8))
+
+	if isDebug() {
+		logDebug("got BYE ", e, " final #", seqno)
+	}
+
+	s, isSender := e.(*sender)
+
+	if !isSender {
+		i.cxt.sm.bye(e)
+		return
+	}
+
+	s.bye = true
+	s.finalSeqno = seqno
+
+	if diff32(seqno, s.expected) >= 0 {
+		if diff32(seqno, s.maxseq) > 0 {
+			s.maxseq = seqno
+		}
+
+		i.cxt.recover.handleLoss(s)
+	}
+
+	i.checkBye(s)
+}
+
+/**
+ * removes a sender which said BYE once its data has been delivered or
+ * given up up to the final sequence number.
+ */
+func (i *impl) checkBye(s *sender) {
+	if !s.bye || diff32(s.expected, s.finalSeqno) <= 0 || i.cxt.sm.get(s.getID()) != s {
+		return
+	}
+
+	if ev := i.cxt.recover.lookup(s, i.cxt.whoami); ev != nil {
+		i.cxt.recover.domain.lossTab.remove(ev)
+	}
+
+	i.cxt.sm.bye(s)
+}
+
+/**
+ * sends a BYE packet with the last sequence number sent.
+ */
+func (i *impl) sendBye() {
+	p := NewPacket(false, 16)
+
+	p.scope = i.ttl
+	p.offset = 0
+
+	p.appendBye(i.cxt.whoami)
+	i.sendControlPacket(p, i.ttl)
+}
+
+/**
+ * keeps the jitter reported by a receiver for the local data.
+ */
+func (i *impl) processJitterReport(e Entity, buff []byte, offset int, len int) {
+	offset += 8
+	len -= 8
+
+	for ; len >= 8; len -= 8 {
+		to := uint32(byteToInt(buff, offset))
+
+		if to == i.cxt.whoami.getID() {
+			i.jitters[e] = fixedPoint32ToMillis(byteToInt(buff, offset+4))
+
+			if isDebug() {
+				logDebug("RJ from ", e, " jitter=", i.jitters[e])
+			}
+		}
+
+		offset += 8
+	}
+}
+
 /* process DATA packet */
 func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 	seqno := int64(byteToInt(buff, offset+12))
@@ -883,20 +1214,25 @@ func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 
 	pack := source.getPacket(seqno)
 
+	delivered := false
+
 	if pack != nil {
 		source.incDuplicate()
 
 		pack.scope = int(buff[offset+1] & 0xff)
-		pack.rcvSendTime = time.Now()
+		pack.rcvSendTime = cxt.clock.Now()
+
+		delivered = pack.delivered
 	}
 
 	/* pack the data into a packet */
 
-	pack = newDataPacket(true, buff, offset, len)
+	pack = newDataPacket(true, buff, offset, len, cxt.clock.Now())
 	pack.seqno = seqno
 	pack.retransmit = false
 	pack.sender = from
 	pack.source = source
+	pack.delivered = delivered
 
 	/*
 	 * update stats.
@@ -913,7 +1249,11 @@ func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 	if isDebug() {
 		logDebug("data/exp:", seqno, "/", source.expected, " @", times(pack.rcvSendTime), " /", pack.scope)
 	}
-	if pack.seqno > source.maxseq {
+	if gap := diff32(pack.seqno, source.maxseq); gap > 0 {
+
+		/* original transmissions missed, whether repaired later or not */
+
+		source.gaps += gap - 1
 		source.maxseq = pack.seqno
 	}
 
@@ -925,7 +1265,7 @@ func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 		/*
 		 * In order, keeps a local copy in cache for local repair.
 		 */
-		if cxt.profile.sendRepair {
+		if cxt.profile.SendRepair {
 			source.putPacket(pack)
 		}
 
@@ -945,6 +1285,7 @@ func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 		 * cache the packet and process loss.
 		 */
 		source.putPacket(pack)
+		i.deliverUnordered(pack)
 		cxt.recover.handleLoss(source)
 	} else {
 
@@ -957,7 +1298,7 @@ func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 		 */
 		i.handleSyncError(source, BufferOverrun)
 
-		if cxt.profile.sendRepair {
+		if cxt.profile.SendRepair {
 			source.putPacket(pack)
 		}
 	}
@@ -969,24 +1310,11 @@ func (i *impl) processData(from Entity, buff []byte, offset int, len int) {
 func (i *impl) handleSyncError(s *sender, cause int) {
 	logError("reception failure @", s.expected, "/", s.maxseq, " cause=", cause)
 
-	/* for continuous losses, we should report only one event */
-
-	var ev *errorEvent
-
-	if s.expected != (s.lastError + 1) {
-		ev = newErrorEvent()
-		ev.source = s
-		ev.loser = i.cxt.whoami
-		ev.cause = cause
-		ev.seqlost = int(s.expected)
-		i.cxt.stats.failures++
+	s.syncErrors++
 
-		if i.cxt.profile.Handler != nil {
-			i.cxt.profile.Handler.ProcessEvent(UNRECOVERABLE_SEQUENCE_ERROR, ev)
-		}
-	}
+	/* continuous losses are reported as one range */
 
-	s.lastError = s.expected
+	i.reportLoss(s, cause, s.expected)
 
 	diff := diff32(s.maxseq, s.expected)
 
@@ -999,34 +1327,15 @@ func (i *impl) handleSyncError(s *sender, cause int) {
 
 		/* deliver in order packets */
 
-		var lastpack *Packet
-
 		for diff > s.cacheSize {
 			pack := s.getPacket(s.expected)
 
 			if pack != nil {
 				i.deliverData(pack)
 			} else {
-				if lastpack != nil {
-					if i.cxt.profile.Handler != nil {
-						if ev == nil {
-							ev = newErrorEvent()
-							ev.source = s
-							ev.loser = i.cxt.whoami
-							ev.cause = cause
-						}
-
-						s.lastError = s.expected
-						ev.seqlost = int(s.expected)
-						i.cxt.stats.failures++
-
-						i.cxt.profile.Handler.ProcessEvent(UNRECOVERABLE_SEQUENCE_ERROR, ev)
-					}
-				}
+				i.reportLoss(s, cause, s.expected)
 			}
 
-			lastpack = pack
-
 			s.incExpected()
 
 			diff--
@@ -1065,6 +1374,39 @@ func (i *impl) handleSyncError(s *sender, cause int) {
 	} else {
 		i.cxt.recover.handleLoss(s)
 	}
+
+	i.checkBye(s)
+}
+
+/**
+ * reports a packet which will never be delivered. The report is kept
+ * pending and extended by the following losses while they are continuous,
+ * it is queued on the next delivery or when the sender leaves.
+ */
+func (i *impl) reportLoss(s *sender, cause int, seqno int64) {
+	s.drops++
+
+	if ev := s.pendingLoss; ev != nil && ev.Last+1 == seqno {
+		ev.Last = seqno
+		return
+	}
+
+	i.cxt.flushLoss(s)
+
+	s.pendingLoss = &SequenceError{Source: identityOf(s), Cause: cause, First: seqno, Last: seqno}
+
+	i.cxt.stats.Failures++
+}
+
+/**
+ * queues the pending loss reports of every sender.
+ */
+func (i *impl) flushLosses() {
+	for _, e := range i.cxt.sm.entities {
+		if s, isSender := e.(*sender); isSender {
+			i.cxt.flushLoss(s)
+		}
+	}
 }
 
 func (i *impl) deliverData(pack *Packet) {
@@ -1074,19 +1416,52 @@ func (i *impl) deliverData(pack *Packet) {
 		}
 
 		/*
-		 * remove from cache if don't participate in local recovery.
+		 * remove from cache if don't participate in local recovery,
+		 * FEC reconstruction needs the delivered packets.
 		 */
-		if !i.cxt.profile.sendRepair {
+		if !i.cxt.profile.SendRepair && !pack.source.(*sender).fecSeen {
 			pack.source.(*sender).removePacket(pack)
 		}
 	} else if isDebug() {
 		logDebug("deliver out-of-band", " len=", pack.datalen)
 	}
-	if i.cxt.profile.Handler != nil {
-		i.cxt.profile.Handler.ProcessData(pack)
+
+	if pack.reliable {
+		i.cxt.flushLoss(pack.source.(*sender))
+	}
+
+	/* already delivered out of order */
+
+	if !pack.delivered {
+		pack.delivered = true
+		i.cxt.processData(pack)
+	}
+
+	if s := pack.source.(*sender); pack.reliable && s.bye && pack.seqno == s.finalSeqno {
+		i.checkBye(s)
 	}
 }
 
+/**
+ * hands an out of order packet to the application at once if the delivery
+ * is not ordered. The packet stays in the cache until the packets before it
+ * are delivered or given up, so the gaps are still recovered.
+ */
+func (i *impl) deliverUnordered(pack *Packet) {
+	if i.cxt.profile.Ordered || pack.delivered {
+		return
+	}
+
+	if isDebug() {
+		logDebug("deliver unordered #", pack.seqno, " len=", pack.datalen, "from", pack.source)
+	}
+
+	i.cxt.flushLoss(pack.source.(*sender))
+
+	pack.delivered = true
+	i.cxt.processData(pack)
+}
+
 /* process R_DATA packet */
 
 func (i *impl) processRepairData(from Entity, buff []byte, offset int, len int) {
@@ -1116,7 +1491,7 @@ func (i *impl) processRepairData(from Entity, buff []byte, offset int, len int)
 
 	if pack != nil {
 		pack.scope = int(buff[offset+1] & 0xff)
-		pack.rcvSendTime = time.Now()
+		pack.rcvSendTime = cxt.clock.Now()
 
 		if source != cxt.whoami {
 			source.incDuplicate()
@@ -1146,7 +1521,7 @@ func (i *impl) processRepairData(from Entity, buff []byte, offset int, len int)
 	/*
 	 * at this point it is really a repair.
 	 */
-	pack = newDataPacket(true, buff, offset, len)
+	pack = newDataPacket(true, buff, offset, len, cxt.clock.Now())
 	pack.retransmit = true
 	pack.seqno = seqno
 	pack.source = source
@@ -1182,7 +1557,7 @@ func (i *impl) processRepairData(from Entity, buff []byte, offset int, len int)
 		/*
 		 * good repair, keeps a local copy in cache for local repair.
 		 */
-		if cxt.profile.sendRepair {
+		if cxt.profile.SendRepair {
 			source.putPacket(pack)
 		}
 
@@ -1202,6 +1577,7 @@ func (i *impl) processRepairData(from Entity, buff []byte, offset int, len int)
 		 * cache the packet and process loss.
 		 */
 		source.putPacket(pack)
+		i.deliverUnordered(pack)
 		cxt.recover.handleLoss(source)
 	}
 
@@ -1212,11 +1588,11 @@ func (i *impl) processRepairData(from Entity, buff []byte, offset int, len int)
 
 /* process U_DATA packet */
 func (i *impl) processUnreliableData(from Entity, buff []byte, offset int, len int) {
-	i.cxt.stats.outOfBand++
+	i.cxt.stats.OutOfBand++
 
 	/* pack the data into a packet */
 
-	pack := newDataPacket(false, buff, offset, len)
+	pack := newDataPacket(false, buff, offset, len, i.cxt.clock.Now())
 
 	pack.source = from
 
@@ -1227,22 +1603,75 @@ func (i *impl) processUnreliableData(from Entity, buff []byte, offset int, len i
 
 /* process F_DATA packet */
 func (i *impl) processFecData(from Entity, buff []byte, offset int, len int) {
+	cxt := i.cxt
+
+	cxt.stats.FecPackets++
+
+	if _, isSender := from.(*sender); !isSender || len < fecHeaderLen+4 {
+
+		/* the data stream has not been heard yet */
+
+		return
+	}
+
+	source := from.(*sender)
+
+	base := int64(byteToInt(buff, offset+8))
+	n := int(buff[offset+12]) + 1
+	nr := int(buff[offset+13]) + 1
+	step := int64(buff[offset+14]) + 1
+
+	/* only a single parity packet per block is supported */
+
+	if nr != 1 || n < 2 {
+		if isDebug() {
+			logDebug("unsupported FEC block ", n, "/", nr)
+		}
+		return
+	}
+
+	datalen := len - fecHeaderLen - 4
+
+	if (buff[offset] & padBit) > 0 {
+		datalen -= int(buff[offset+len-1] & 0xff)
+	}
+	if datalen < 0 {
+		cxt.stats.BadLength++
+		return
+	}
+
+	b := fecBlock{base: base, k: n - nr, step: step}
+	b.lenxor = byteToShort(buff, offset+fecHeaderLen)
+	b.parity = buff[offset+fecHeaderLen+4 : offset+fecHeaderLen+4+datalen]
+
+	if isDebug() {
+		logDebug("FEC block ", base, "/", b.k, " from ", source)
+	}
+
+	source.addFecBlock(&b)
+
+	i.fecRecover(source)
+
+	if diff32(source.maxseq, source.expected) >= 0 {
+		cxt.recover.handleLoss(source)
+	}
 }
 func (i *impl) sendDataPacket(pack *Packet, resend bool) {
-	len := pack.formatDataPacket(resend)
+	now := i.cxt.clock.Now()
+	len := pack.formatDataPacket(resend, now)
 
 	i.session.send(pack.buff, len, pack.scope)
 
 	if resend {
 		d := i.cxt.recover.lookupDomain(pack.scope)
 
-		d.stats.repairPackets++
-		d.stats.repairBytes += int64(len)
+		d.stats.RepairPackets++
+		d.stats.RepairBytes += int64(len)
 
 		i.cxt.whoami.incRepairs()
 	}
 
-	pack.rcvSendTime = time.Now()
+	pack.rcvSendTime = now
 
 	if pack.reliable { /* XXXXXXXXX */
 		i.cxt.whoami.lastTimeForData = pack.rcvSendTime
@@ -1252,6 +1681,6 @@ func (i *impl) sendDataPacket(pack *Packet, resend bool) {
 	i.cxt.whoami.incPackets()
 	i.cxt.whoami.incBytes(len)
 
-	i.cxt.stats.dataPackets++
-	i.cxt.stats.dataBytes += int64(len)
+	i.cxt.stats.DataPackets++
+	i.cxt.stats.DataBytes += int64(len)
 }
@@ -0,0 +1,176 @@
+package lrmp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+/**
+ * a transport keeping the packets written, nothing is read.
+ */
+type captureTransport struct {
+	sync.Mutex
+	sent [][]byte
+}
+
+func (t *captureTransport) ReadFrom(b []byte) (int, net.IP, error) {
+	return 0, nil, errors.New("no packets")
+}
+
+func (t *captureTransport) WriteTo(b []byte, ttl int) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+	t.sent = append(t.sent, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (t *captureTransport) LocalAddr() net.IP {
+	return net.ParseIP("10.0.0.1")
+}
+
+func (t *captureTransport) Close() error {
+	return nil
+}
+
+/* the reports due for many senders at once are split over several packets */
+func TestReceiverReportsSplitOverPackets(t *testing.T) {
+	transport := &captureTransport{}
+
+	profile := NewProfile()
+	profile.Clock = NewVirtualClock(epoch)
+	profile.JitterReports = true
+
+	i, err := newImpl(transport, 1, *profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.stopSession()
+
+	const senders = 100
+
+	i.cxt.mu.Lock()
+	for id := uint32(1); id <= senders; id++ {
+		s := newSender(id, net.ParseIP("10.0.1.1"), 0)
+		s.rrProb = 1
+		i.reports[s] = s
+	}
+	i.cxt.mu.Unlock()
+
+	i.handleTimerTask(nil, i.cxt.clock.Now())
+
+	transport.Lock()
+	defer transport.Unlock()
+
+	if len(transport.sent) < 2 {
+		t.Fatalf("%d reports sent in %d packets", senders, len(transport.sent))
+	}
+
+	reports := map[int]int{}
+
+	for _, p := range transport.sent {
+		for offset := 0; offset < len(p); {
+			reports[int(p[offset]&0x3f)]++
+			offset += byteToShort(p, offset+2)
+		}
+	}
+	if reports[RR_PT] != senders || reports[RJ_PT] != senders {
+		t.Fatalf("%d receiver and %d jitter reports, want %d", reports[RR_PT], reports[RJ_PT], senders)
+	}
+}
+
+/*
+ * the rate is increased by 1/8 at a check only once the window time has
+ * elapsed since the last adjustment, and every change is kept in the
+ * history up to rateHistorySize.
+ */
+func TestRateIncreaseGatedByWindowTime(t *testing.T) {
+	profile := NewProfile()
+	clock := NewVirtualClock(epoch)
+	profile.Clock = clock
+
+	i, err := newImpl(&captureTransport{}, 1, *profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer i.stopSession()
+
+	cxt := i.cxt
+	f := cxt.sender
+	reporter := newSender(1, net.ParseIP("10.0.0.2"), 0)
+
+	/* a check interval of 100 byte packets sent after d, with an optional rate adjustment */
+
+	check := func(d time.Duration, adjust int) int {
+		clock.Advance(d)
+
+		cxt.mu.Lock()
+		defer cxt.mu.Unlock()
+
+		cxt.whoami.packets += cxt.checkInterval
+		cxt.whoami.bytes += cxt.checkInterval * 100
+
+		if adjust != None {
+			f.adjustRate(reporter, adjust)
+		}
+		f.flowControl()
+
+		return cxt.curRate
+	}
+
+	check(time.Second, None)
+	rate := check(time.Second, MediumDecrease)
+
+	/* the window time is 1/8 of the time to send the window at the current rate */
+
+	windowTime := time.Duration(profile.SendWindowSize*100*1000/rate>>3) * time.Millisecond
+
+	for elapsed := 10 * time.Millisecond; elapsed < windowTime; elapsed += 10 * time.Millisecond {
+		if r := check(10*time.Millisecond, None); r != rate {
+			t.Fatalf("rate changed from %d to %d %v after a decrease, window time %v", rate, r, elapsed, windowTime)
+		}
+	}
+
+	if r := check(10*time.Millisecond, None); r != rate*9>>3 {
+		t.Fatalf("rate %d after the window time, want %d", r, rate*9>>3)
+	}
+
+	/* the next increase waits for the window time again */
+
+	rate = cxt.curRate
+	if r := check(10*time.Millisecond, None); r != rate {
+		t.Fatalf("rate increased from %d to %d right after an increase", rate, r)
+	}
+
+	/* more changes than kept, the rate going up and down between the bounds */
+
+	for n := 0; n < rateHistorySize+8; n++ {
+		if rate > 3000 {
+			rate = check(time.Second, SmallDecrease)
+		} else {
+			rate = check(time.Second, None)
+		}
+	}
+
+	cxt.mu.Lock()
+	history := append([]RateChange(nil), f.history...)
+	rate = cxt.curRate
+	cxt.mu.Unlock()
+
+	if len(history) != rateHistorySize {
+		t.Fatalf("%d rate changes kept", len(history))
+	}
+	for n, c := range history {
+		if n > 0 && (c.From != history[n-1].To || !c.Time.After(history[n-1].Time)) {
+			t.Fatalf("change %+v after %+v", c, history[n-1])
+		}
+		if c.To != c.From*SmallDecrease>>3 && c.To != c.From*SmallIncrease>>3 {
+			t.Fatalf("change %+v neither a small decrease nor an increase", c)
+		}
+	}
+	if last := history[len(history)-1]; last.To != rate || !last.Time.Equal(clock.Now()) {
+		t.Fatalf("last change %+v, rate %d at %v", last, rate, clock.Now())
+	}
+}
@@ -0,0 +1,63 @@
+package lrmp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/**
+ * returns the ID of the member at addr as known by l, 0 if unknown.
+ */
+func memberID(l *lrmp.Lrmp, addr string) uint32 {
+	members := l.Members()
+
+	for _, m := range members.Senders {
+		if m.Identity.Addr.String() == addr {
+			return m.Identity.ID
+		}
+	}
+	for _, m := range members.Receivers {
+		if m.Identity.Addr.String() == addr {
+			return m.Identity.ID
+		}
+	}
+	return 0
+}
+
+/* only the receivers with JitterReports send the jitter to the sender */
+func TestJitterReports(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 10 * time.Millisecond, Jitter: 8 * time.Millisecond})
+
+	p := n.profile()
+	p.RcvReportSelInterval = 1000
+	a := n.join("10.0.0.1", p)
+
+	p = n.profile()
+	p.JitterReports = true
+	b := n.join("10.0.0.2", p)
+
+	c := n.join("10.0.0.3", n.profile())
+
+	for i := 0; i < 100; i++ {
+		sendPackets(t, a, i, 1)
+		n.run(20 * time.Millisecond)
+	}
+
+	reported := func() bool { _, ok := a.ReportedJitter()[memberID(a, "10.0.0.2")]; return ok }
+
+	if !n.runUntil(time.Minute, reported) {
+		t.Fatal("no jitter reported")
+	}
+	if _, ok := a.ReportedJitter()[memberID(a, "10.0.0.3")]; ok {
+		t.Fatal("jitter reported without JitterReports")
+	}
+
+	for _, l := range []*lrmp.Lrmp{b, c} {
+		if j := l.ReceptionJitter()[memberID(l, "10.0.0.1")]; j <= 0 {
+			t.Fatalf("reception jitter %v", j)
+		}
+	}
+}
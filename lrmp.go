@@ -1,36 +1,277 @@
 package lrmp
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"time"
+)
 
 var Version = "LRMP-1.4.2"
 
+// returned by the send methods once the session is closed or stopped
+var ErrClosed = errors.New("session closed")
+
+// returned by TrySend when the packet cannot be queued without waiting
+var ErrQueueFull = errors.New("send queue full")
+
+// returned by TrySend when another send, such as a SendMessage, is queueing
+// packets
+var ErrBusy = errors.New("send in progress")
+
 type Lrmp struct {
 	impl *impl
+	/* keeps the fragments of a message consecutive, waiting can be cancelled */
+	sendSem chan struct{}
+}
+
+// a receiver whose loss and congestion reports decreased the transmission
+// rate. The reports of an ignored receiver no longer affect the rate, until
+// it has stopped causing decreases for a while.
+type SlowReceiver struct {
+	ID        uint32
+	Addr      net.IP
+	Decreases int
+	Ignored   bool
 }
 
 // create and join an LRMP session
 func NewLrmp(addr string, port int, ttl int, network string, profile Profile) (*Lrmp, error) {
-	impl, err := newImpl(addr, port, ttl, network, profile)
+	transport, err := NewMulticastTransport(addr, port, network)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := NewLrmpWithTransport(transport, ttl, profile)
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// create an LRMP session over the given transport, the TTL is checked as
+// by SetTTL
+func NewLrmpWithTransport(transport Transport, ttl int, profile Profile) (*Lrmp, error) {
+	if err := profile.Validate(); err != nil {
+		return nil, err
+	}
+
+	impl, err := newImpl(transport, ttl, profile)
 	if err != nil {
 		return nil, err
 	}
 
-	lrmp := Lrmp{impl}
+	lrmp := Lrmp{impl: impl, sendSem: make(chan struct{}, 1)}
 	return &lrmp, nil
 }
 
 func (l *Lrmp) Start() {
 	l.impl.startSession()
 }
+
+// leaves the session at once, the packets not yet sent are dropped
 func (l *Lrmp) Stop() {
 	l.impl.stopSession()
 }
 
+// leaves the session gracefully: no more packets are accepted, those queued
+// are sent and the repairs are answered during the linger time of the
+// profile before the session is stopped. When the context is done first,
+// the session is stopped at once and the context error is returned, and
+// when Stop is called meanwhile ErrClosed is returned.
+func (l *Lrmp) Close(ctx context.Context) error {
+	return l.impl.close(ctx)
+}
+
+// returns the profile of the session
+func (l *Lrmp) Profile() Profile {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return *l.impl.cxt.profile
+}
+
+// changes the profile of the session, which may be running. The data
+// already sent and received is kept.
+func (l *Lrmp) SetProfile(profile Profile) error {
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	l.impl.cxt.setProfile(&profile)
+
+	return nil
+}
+
+// applies a change to the current profile
+func (l *Lrmp) updateProfile(update func(profile *Profile)) error {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	profile := *l.impl.cxt.profile
+
+	update(&profile)
+
+	if err := profile.Validate(); err != nil {
+		return err
+	}
+
+	l.impl.cxt.setProfile(&profile)
+
+	return nil
+}
+
+// changes the bounds of the transmission rate, in kilo bits/sec
+func (l *Lrmp) SetRate(minRate, maxRate int) error {
+	return l.updateProfile(func(profile *Profile) {
+		profile.MinRate = minRate
+		profile.MaxRate = maxRate
+	})
+}
+
+// changes the number of packets kept for repairs by the sender and for
+// reordering by the receivers
+func (l *Lrmp) SetWindowSize(sendWindowSize, rcvWindowSize int) error {
+	return l.updateProfile(func(profile *Profile) {
+		profile.SendWindowSize = sendWindowSize
+		profile.RcvWindowSize = rcvWindowSize
+	})
+}
+
+// changes the reliability mode, LossAllowed, LimitedLoss or NoLoss
+func (l *Lrmp) SetReliability(reliability int) error {
+	return l.updateProfile(func(profile *Profile) {
+		profile.Reliability = reliability
+	})
+}
+
+// returns the TTL of the session
+func (l *Lrmp) TTL() int {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return l.impl.ttl
+}
+
+// changes the TTL of the session, the recovery domains are rebuilt for the
+// new scope. The TTL must be in 0..255 and within the scope of the group
+// for a ScopedTransport.
+func (l *Lrmp) SetTTL(ttl int) error {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return l.impl.setTTL(ttl)
+}
+
+// returns a snapshot of the session counters
 func (l *Lrmp) Stats() Stats {
-	return l.impl.stats
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return l.impl.cxt.stats
 }
+
+// returns a snapshot of the smallest recovery domain which covers the given
+// scope
 func (l *Lrmp) DomainStats(scope int) DomainStats {
-	return l.impl.domainStats
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return l.impl.cxt.recover.scopeDomain(scope).stats
+}
+
+// returns a snapshot of every recovery domain, from the top level domain
+// down to the lowest one
+func (l *Lrmp) Domains() []DomainStats {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	var domains []DomainStats
+
+	for _, d := range l.impl.cxt.recover.domains() {
+		domains = append(domains, d.stats)
+	}
+	return domains
+}
+
+// returns the recent mean round trip time updates of the recovery domain
+// with the given scope, oldest first
+func (l *Lrmp) MRTTHistory(scope int) []MRTTSample {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	d := l.impl.cxt.recover.scopeDomain(scope)
+
+	return append([]MRTTSample(nil), d.mrttHistory...)
+}
+
+// returns the recent adjustments of the transmission rate, oldest first
+func (l *Lrmp) RateHistory() []RateChange {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return append([]RateChange(nil), l.impl.cxt.sender.history...)
+}
+
+// returns the senders and receivers of the session heard so far
+func (l *Lrmp) Members() Members {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	return l.impl.cxt.sm.members()
+}
+
+// returns the receivers which caused rate decreases, most decreases first
+func (l *Lrmp) SlowReceivers() []SlowReceiver {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	var slow []SlowReceiver
+
+	for id, e := range l.impl.cxt.sm.entities {
+		if e.getDecreases() > 0 || e.isIgnored() {
+			slow = append(slow, SlowReceiver{ID: id, Addr: e.getAddress(), Decreases: e.getDecreases(), Ignored: e.isIgnored()})
+		}
+	}
+
+	sort.Slice(slow, func(i, j int) bool { return slow[i].Decreases > slow[j].Decreases })
+
+	return slow
+}
+
+// returns the interarrival jitter of the data received from each sender,
+// keyed by sender ID
+func (l *Lrmp) ReceptionJitter() map[uint32]time.Duration {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	jitters := make(map[uint32]time.Duration)
+
+	for id, e := range l.impl.cxt.sm.entities {
+		if s, isSender := e.(*sender); isSender && s != l.impl.cxt.whoami && s.packets > 0 {
+			jitters[id] = time.Duration(s.getJitter()) * time.Millisecond
+		}
+	}
+	return jitters
+}
+
+// returns the interarrival jitter of the local data reported by the
+// receivers with JitterReports in their profile, keyed by receiver ID
+func (l *Lrmp) ReportedJitter() map[uint32]time.Duration {
+	l.impl.cxt.mu.Lock()
+	defer l.impl.cxt.mu.Unlock()
+
+	jitters := make(map[uint32]time.Duration)
+
+	for e, jitter := range l.impl.jitters {
+		jitters[e.getID()] = time.Duration(jitter) * time.Millisecond
+	}
+	return jitters
 }
 func (l *Lrmp) WhoAmI() Entity {
 	return l.impl.whoAmI()
@@ -40,8 +281,70 @@ func (l *Lrmp) Send(packet *Packet) error {
 	if packet.GetDataLength() > packet.GetMaxDataLength() {
 		return errors.New("bad packet length")
 	}
+
+	l.sendSem <- struct{}{}
+	defer func() { <-l.sendSem }()
+
 	return l.impl.send(packet)
 }
+
+// sends the packet like Send, but gives up when the context is done while
+// waiting for room in the send queue
+func (l *Lrmp) SendContext(ctx context.Context, packet *Packet) error {
+	if packet.GetDataLength() > packet.GetMaxDataLength() {
+		return errors.New("bad packet length")
+	}
+
+	select {
+	case l.sendSem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-l.sendSem }()
+
+	return l.impl.sendContext(ctx, packet)
+}
+
+// sends the packet only if it can be queued at once. Returns ErrQueueFull
+// when the send queue is full, and ErrBusy while another send is queueing
+// its packets, for instance the fragments of a message sent by SendMessage.
+func (l *Lrmp) TrySend(packet *Packet) error {
+	if packet.GetDataLength() > packet.GetMaxDataLength() {
+		return errors.New("bad packet length")
+	}
+
+	select {
+	case l.sendSem <- struct{}{}:
+	default:
+		return ErrBusy
+	}
+	defer func() { <-l.sendSem }()
+
+	return l.impl.trySend(packet)
+}
+
+// returns the number of packets waiting in the send queue
+func (l *Lrmp) QueueLen() int {
+	return l.impl.cxt.sender.queueLen()
+}
+
+// returns the capacity of the send queue
+func (l *Lrmp) QueueCap() int {
+	return cap(l.impl.cxt.sendQueue)
+}
+
+// sends a message of any length up to MaxMessageSize as consecutive reliable
+// packets, the receivers get it in one piece through a MessageAssembler
+func (l *Lrmp) SendMessage(msg []byte) error {
+	if len(msg) > MaxMessageSize {
+		return errors.New("message too large")
+	}
+
+	l.sendSem <- struct{}{}
+	defer func() { <-l.sendSem }()
+
+	return l.impl.sendMessage(msg)
+}
 func (l *Lrmp) Flush() {
 	l.impl.flush()
 }
@@ -0,0 +1,296 @@
+package lrmp_test
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+/* the virtual time advanced at once by run and runUntil by default */
+const defaultStep = 5 * time.Millisecond
+
+func TestMain(m *testing.M) {
+	lrmp.LogWriter = io.Discard
+	os.Exit(m.Run())
+}
+
+/**
+ * a virtual network and clock shared by the sessions of a test.
+ */
+type testNet struct {
+	t     *testing.T
+	clock *lrmp.VirtualClock
+	group *vnet.Group
+	/* larger steps run long idle periods faster */
+	step time.Duration
+}
+
+func newTestNet(t *testing.T, link vnet.LinkConfig) *testNet {
+	n := testNet{t: t, clock: lrmp.NewVirtualClock(epoch), group: vnet.NewGroup(1), step: defaultStep}
+	n.group.SetClock(n.clock)
+	n.group.SetDefaultLink(link)
+	return &n
+}
+
+/**
+ * returns the default profile using the clock of the network.
+ */
+func (n *testNet) profile() *lrmp.Profile {
+	p := lrmp.NewProfile()
+	p.Clock = n.clock
+	return p
+}
+
+/**
+ * creates and starts a session at addr with a TTL of 1, it is stopped at the
+ * end of the test.
+ */
+func (n *testNet) join(addr string, profile *lrmp.Profile) *lrmp.Lrmp {
+	n.t.Helper()
+	return n.joinTTL(addr, 1, profile)
+}
+
+func (n *testNet) joinTTL(addr string, ttl int, profile *lrmp.Profile) *lrmp.Lrmp {
+	n.t.Helper()
+
+	return n.joinWith(n.group.Join(net.ParseIP(addr)), ttl, profile)
+}
+
+/**
+ * creates and starts a session over the given transport, e.g. one wrapping
+ * an endpoint of the group.
+ */
+func (n *testNet) joinWith(transport lrmp.Transport, ttl int, profile *lrmp.Profile) *lrmp.Lrmp {
+	n.t.Helper()
+
+	l, err := lrmp.NewLrmpWithTransport(transport, ttl, *profile)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	l.Start()
+	n.t.Cleanup(l.Stop)
+	return l
+}
+
+/**
+ * advances the clock by d in steps, letting the goroutines of the sessions
+ * run in between.
+ */
+func (n *testNet) run(d time.Duration) {
+	for ; d > 0; d -= n.step {
+		n.clock.Advance(n.step)
+		time.Sleep(50 * time.Microsecond)
+	}
+}
+
+/**
+ * advances the clock until cond holds, at most by limit. Returns cond.
+ */
+func (n *testNet) runUntil(limit time.Duration, cond func() bool) bool {
+	for elapsed := time.Duration(0); !cond(); elapsed += n.step {
+		if elapsed >= limit {
+			return false
+		}
+		n.clock.Advance(n.step)
+		time.Sleep(50 * time.Microsecond)
+	}
+	return true
+}
+
+/**
+ * a transport losing the data packets received with the given payloads, so
+ * that a test chooses exactly which packets are missing.
+ */
+type payloadLoss struct {
+	*vnet.Endpoint
+	sync.Mutex
+	drop map[string]bool
+	/* lose the repairs of the packets too */
+	repairs bool
+	/* lose the NACKs sent */
+	nacks   bool
+	dropped int
+}
+
+func newPayloadLoss(e *vnet.Endpoint, drop ...string) *payloadLoss {
+	t := payloadLoss{Endpoint: e, drop: make(map[string]bool)}
+	for _, s := range drop {
+		t.drop[s] = true
+	}
+	return &t
+}
+
+func (t *payloadLoss) ReadFrom(b []byte) (int, net.IP, error) {
+	for {
+		n, src, err := t.Endpoint.ReadFrom(b)
+		if err != nil || !t.lose(b[:n]) {
+			return n, src, err
+		}
+	}
+}
+
+func (t *payloadLoss) WriteTo(b []byte, ttl int) (int, error) {
+	if t.nacks && b[0]&0x1f == lrmp.NACK_PT {
+		return len(b), nil
+	}
+	return t.Endpoint.WriteTo(b, ttl)
+}
+
+func (t *payloadLoss) lose(b []byte) bool {
+	if len(b) < 16 {
+		return false
+	}
+	if pt := b[0] & 0x1f; pt != lrmp.DATA_PT && (pt != lrmp.R_DATA_PT || !t.repairs) {
+		return false
+	}
+
+	end := len(b)
+	if b[0]&0x20 != 0 {
+		end -= int(b[end-1])
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.drop[string(b[16:end])] {
+		return false
+	}
+	t.dropped++
+	return true
+}
+
+func (t *payloadLoss) count() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.dropped
+}
+
+func newPacket(data string) *lrmp.Packet {
+	p := lrmp.NewPacket(true, len(data))
+	copy(p.GetDataBuffer(), data)
+	p.SetDataLength(len(data))
+	return p
+}
+
+/**
+ * sends count reliable packets numbered from first.
+ */
+func sendPackets(t *testing.T, l *lrmp.Lrmp, first int, count int) {
+	t.Helper()
+
+	for i := first; i < first+count; i++ {
+		if err := l.Send(newPacket(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+/**
+ * recorder is a handler keeping the data and events it receives.
+ */
+type recorder struct {
+	sync.Mutex
+	data   []string
+	seqnos []int64
+	events []lrmp.Event
+	/* the number of packets received before each event */
+	at []int
+}
+
+func (r *recorder) ProcessData(p *lrmp.Packet) {
+	r.Lock()
+	defer r.Unlock()
+	r.data = append(r.data, string(p.GetDataBuffer()[:p.GetDataLength()]))
+	r.seqnos = append(r.seqnos, p.GetSeqno())
+}
+
+func (r *recorder) ProcessEvent(event int, data interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	if e, ok := data.(lrmp.Event); ok {
+		r.events = append(r.events, e)
+		r.at = append(r.at, len(r.data))
+	}
+}
+
+func (r *recorder) received() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string(nil), r.data...)
+}
+
+func (r *recorder) count() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.data)
+}
+
+func (r *recorder) eventTypes() []int {
+	r.Lock()
+	defer r.Unlock()
+
+	var types []int
+	for _, e := range r.events {
+		types = append(types, e.Type())
+	}
+	return types
+}
+
+/**
+ * returns the types of the events about the member at addr.
+ */
+func (r *recorder) eventsOf(addr string) []int {
+	r.Lock()
+	defer r.Unlock()
+
+	var types []int
+	for _, e := range r.events {
+		var id lrmp.Identity
+
+		switch e := e.(type) {
+		case *lrmp.SequenceError:
+			id = e.Source
+		case *lrmp.EndOfSequence:
+			id = e.Source
+		case *lrmp.MemberJoined:
+			id = e.Member
+		case *lrmp.MemberSender:
+			id = e.Member
+		case *lrmp.MemberLeft:
+			id = e.Member
+		case *lrmp.MemberReplaced:
+			id = e.Member
+		}
+
+		if id.Addr.String() == addr {
+			types = append(types, e.Type())
+		}
+	}
+	return types
+}
+
+/**
+ * checks that the packets "0" to "count-1" were received once and in order.
+ */
+func checkInOrder(t *testing.T, r *recorder, count int) {
+	t.Helper()
+
+	data := r.received()
+	if len(data) != count {
+		t.Fatalf("received %d packets, want %d", len(data), count)
+	}
+	for i, d := range data {
+		if d != strconv.Itoa(i) {
+			t.Fatalf("packet %d is %q", i, d)
+		}
+	}
+}
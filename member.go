@@ -0,0 +1,100 @@
+package lrmp
+
+import (
+	"sort"
+	"time"
+)
+
+/**
+ * Member describes a remote entity of the session as known locally. RTT is
+ * zero until measured, Distance is the approximate number of hops from the
+ * local site and Nacks the number of NACKs received from the member.
+ */
+type Member struct {
+	Identity  Identity
+	RTT       time.Duration
+	Distance  int
+	LastHeard time.Time
+	Nacks     int
+}
+
+/**
+ * SenderMember is a member which sends data, with the state of the local
+ * reception of its data. Expected is the next sequence number to deliver
+ * and MaxSeqno the highest one received, Rate is the data rate in bytes per
+ * second announced in its sender reports. Drops is the number of packets
+ * given up on, and Lost is set once the sender has left, e.g. said BYE while
+ * its last packets are still being recovered.
+ */
+type SenderMember struct {
+	Member
+	Expected   int64
+	MaxSeqno   int64
+	Packets    int
+	Bytes      int
+	Rate       int
+	Duplicates int
+	Repairs    int
+	Drops      int
+	Jitter     time.Duration
+	Lost       bool
+}
+
+/**
+ * Members is a snapshot of the session membership, excluding the local
+ * entity, ordered by ID.
+ */
+type Members struct {
+	Senders   []SenderMember
+	Receivers []Member
+}
+
+func memberOf(e Entity) Member {
+	return Member{
+		Identity:  identityOf(e),
+		RTT:       time.Duration(e.getRTT()) * time.Millisecond,
+		Distance:  e.getDistance(),
+		LastHeard: e.getLastTimeHeard(),
+		Nacks:     e.getNacks(),
+	}
+}
+
+func senderMemberOf(s *sender) SenderMember {
+	return SenderMember{
+		Member:     memberOf(s),
+		Expected:   s.expected,
+		MaxSeqno:   s.maxseq,
+		Packets:    s.packets,
+		Bytes:      s.bytes,
+		Rate:       s.rate,
+		Duplicates: s.duplicates,
+		Repairs:    s.repairs,
+		Drops:      s.drops,
+		Jitter:     time.Duration(s.getJitter()) * time.Millisecond,
+		Lost:       s.lost || s.bye,
+	}
+}
+
+/**
+ * returns the members known by the entity manager, a remote entity is a
+ * sender once data or a sender report has been received from it.
+ */
+func (m *entityManager) members() Members {
+	var members Members
+
+	for _, e := range m.entities {
+		if e == m.whoami {
+			continue
+		}
+		if s, isSender := e.(*sender); isSender {
+			members.Senders = append(members.Senders, senderMemberOf(s))
+		} else {
+			members.Receivers = append(members.Receivers, memberOf(e))
+		}
+	}
+
+	sort.Slice(members.Senders, func(i, j int) bool { return members.Senders[i].Identity.ID < members.Senders[j].Identity.ID })
+	sort.Slice(members.Receivers, func(i, j int) bool { return members.Receivers[i].Identity.ID < members.Receivers[j].Identity.ID })
+
+	return members
+}
@@ -0,0 +1,122 @@
+package lrmp_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp/vnet"
+)
+
+/* a remote entity is a sender once its data is received, the others are receivers */
+func TestMembers(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 10 * time.Millisecond})
+
+	/* the receivers are asked for reports every second */
+
+	p := n.profile()
+	p.RcvReportSelInterval = 1000
+	a := n.join("10.0.0.1", p)
+	b := n.join("10.0.0.2", n.profile())
+	n.join("10.0.0.3", n.profile())
+
+	if members := a.Members(); len(members.Senders) != 0 || len(members.Receivers) != 0 {
+		t.Fatalf("members %+v before any packet", members)
+	}
+
+	sendPackets(t, a, 0, 20)
+
+	if !n.runUntil(time.Minute, func() bool { return len(a.Members().Receivers) == 2 }) {
+		t.Fatalf("sender knows %+v", a.Members())
+	}
+
+	members := a.Members()
+	if len(members.Senders) != 0 {
+		t.Fatalf("sender knows senders %+v", members.Senders)
+	}
+	if members.Receivers[0].Identity.ID > members.Receivers[1].Identity.ID {
+		t.Fatalf("receivers %+v not ordered by ID", members.Receivers)
+	}
+	for _, m := range members.Receivers {
+		if m.RTT < 15*time.Millisecond || m.RTT > 30*time.Millisecond {
+			t.Fatalf("receiver %v with rtt %v over a link with a round trip time of 20ms", m.Identity.Addr, m.RTT)
+		}
+		if m.LastHeard.IsZero() || m.LastHeard.After(n.clock.Now()) {
+			t.Fatalf("receiver %v last heard at %v", m.Identity.Addr, m.LastHeard)
+		}
+	}
+
+	/* the receivers see the sender with the state of the reception */
+
+	members = b.Members()
+	if len(members.Senders) != 1 {
+		t.Fatalf("receiver knows senders %+v", members.Senders)
+	}
+
+	s := members.Senders[0]
+	if s.Identity.Addr.String() != "10.0.0.1" {
+		t.Fatalf("sender %+v", s.Identity)
+	}
+	if s.Packets != 20 || s.Bytes == 0 || s.Expected != s.MaxSeqno+1 || s.Lost {
+		t.Fatalf("sender %+v after 20 packets", s)
+	}
+
+	/* the snapshot is not changed by the session */
+
+	s.Packets = 0
+	if b.Members().Senders[0].Packets != 20 {
+		t.Fatal("snapshot shares the state of the session")
+	}
+}
+
+/*
+ * a packet which can't be repaired is dropped, and a sender which said BYE
+ * is lost while its last packets are still recovered.
+ */
+func TestMemberDropsAndLost(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 10 * time.Millisecond})
+
+	a := n.join("10.0.0.1", n.profile())
+
+	transport := newPayloadLoss(n.group.Join(net.ParseIP("10.0.0.2")), "1")
+	transport.repairs = true
+	transport.nacks = true
+
+	p := n.profile()
+	p.MaxTries = 2
+	b := n.joinWith(transport, 1, p)
+
+	sendPackets(t, a, 0, 10)
+
+	if !n.runUntil(time.Minute, func() bool {
+		members := b.Members()
+		return len(members.Senders) == 1 && members.Senders[0].Packets == 9 && members.Senders[0].Expected == members.Senders[0].MaxSeqno+1
+	}) {
+		t.Fatalf("receiver knows %+v", b.Members())
+	}
+	if s := b.Members().Senders[0]; s.Drops != 1 || s.Lost {
+		t.Fatalf("sender %+v after a loss given up", s)
+	}
+
+	transport.Lock()
+	transport.drop["12"] = true
+	transport.Unlock()
+
+	sendPackets(t, a, 10, 5)
+	closeAsync(context.Background(), a)
+
+	if !n.runUntil(time.Minute, func() bool {
+		members := b.Members()
+		return len(members.Senders) == 1 && members.Senders[0].Lost
+	}) {
+		t.Fatalf("receiver knows %+v after BYE", b.Members())
+	}
+	if s := b.Members().Senders[0]; s.Drops != 1 {
+		t.Fatalf("sender %+v before the last loss is given up", s)
+	}
+
+	if !n.runUntil(time.Minute, func() bool { return len(b.Members().Senders) == 0 }) {
+		t.Fatalf("receiver knows %+v after BYE", b.Members())
+	}
+}
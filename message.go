@@ -0,0 +1,180 @@
+package lrmp
+
+/*
+ * Messages larger than a packet are sent as fragments over consecutive
+ * reliable sequence numbers. Each fragment starts with a header:
+ *
+ *   flags (8 bits) | fragment index in the message (24 bits)
+ *
+ * The receiver reassembles the fragments in order with a MessageAssembler.
+ * Fragments are small enough to be protected by FEC.
+ */
+
+const fragmentHeaderLen = 4
+const maxFragmentLen = fecMaxData - fragmentHeaderLen
+
+const (
+	fragmentFirst = 0x80
+	fragmentLast  = 0x40
+)
+
+/**
+ * the maximum length of a message.
+ */
+const MaxMessageSize = 16 << 20
+
+/**
+ * MessageHandler receives complete messages from a MessageAssembler. Events
+ * are passed through, an UNRECOVERABLE_SEQUENCE_ERROR means that the
+ * message in progress from the source is lost.
+ */
+type MessageHandler interface {
+	ProcessMessage(source Identity, msg []byte)
+	ProcessEvent(event int, data interface{})
+}
+
+type partialMessage struct {
+	data  []byte
+	next  int64
+	index int
+}
+
+/**
+ * MessageAssembler is the EventHandler to set in the profile to receive
+ * the messages sent with SendMessage. The profile must be ordered.
+ */
+type MessageAssembler struct {
+	handler  MessageHandler
+	partials map[uint32]*partialMessage
+}
+
+func NewMessageAssembler(handler MessageHandler) *MessageAssembler {
+	a := MessageAssembler{handler: handler, partials: make(map[uint32]*partialMessage)}
+	return &a
+}
+
+func (a *MessageAssembler) needsOrder() {}
+
+func (a *MessageAssembler) ProcessData(p *Packet) {
+	if !p.reliable || p.datalen < fragmentHeaderLen {
+		logError("not a message fragment from ", p.source)
+		return
+	}
+
+	buff := p.GetDataBuffer()[:p.datalen]
+	flags := buff[0]
+	index := int(buff[1])<<16 | int(buff[2])<<8 | int(buff[3])
+
+	id := p.source.getID()
+
+	m := a.partials[id]
+
+	if flags&fragmentFirst != 0 {
+		if m != nil {
+			a.incomplete(p, m)
+		}
+
+		m = &partialMessage{}
+		a.partials[id] = m
+	} else if m == nil {
+
+		/* the beginning of the message is missing, already reported */
+
+		return
+	} else if diff32(p.seqno, m.next) != 0 || index != m.index {
+		a.incomplete(p, m)
+		return
+	}
+
+	if len(m.data)+len(buff)-fragmentHeaderLen > MaxMessageSize {
+		logError("message too large from ", p.source)
+		a.incomplete(p, m)
+		return
+	}
+
+	m.data = append(m.data, buff[fragmentHeaderLen:]...)
+	m.next = p.seqno + 1
+	m.index++
+
+	if flags&fragmentLast != 0 {
+		delete(a.partials, id)
+		a.handler.ProcessMessage(identityOf(p.source), m.data)
+	}
+}
+
+func (a *MessageAssembler) ProcessEvent(event int, data interface{}) {
+	switch ev := data.(type) {
+	case *SequenceError:
+
+		/* the message in progress is lost */
+
+		delete(a.partials, ev.Source.ID)
+	case *EndOfSequence:
+		delete(a.partials, ev.Source.ID)
+	}
+
+	a.handler.ProcessEvent(event, data)
+}
+
+/**
+ * drops the message in progress which misses the fragments before p, and
+ * reports it as a reception failure.
+ */
+func (a *MessageAssembler) incomplete(p *Packet, m *partialMessage) {
+	delete(a.partials, p.source.getID())
+
+	last := p.seqno - 1
+
+	if diff32(last, m.next) < 0 {
+		last = m.next
+	}
+
+	ev := SequenceError{Source: identityOf(p.source), Cause: Unknown, First: m.next, Last: last}
+
+	a.handler.ProcessEvent(UNRECOVERABLE_SEQUENCE_ERROR, &ev)
+}
+
+/**
+ * sends the message as consecutive reliable packets.
+ */
+func (i *impl) sendMessage(msg []byte) error {
+	offset := 0
+
+	for index := 0; ; index++ {
+		n := len(msg) - offset
+
+		if n > maxFragmentLen {
+			n = maxFragmentLen
+		}
+
+		flags := 0
+
+		if offset == 0 {
+			flags |= fragmentFirst
+		}
+		if offset+n == len(msg) {
+			flags |= fragmentLast
+		}
+
+		p := NewPacket(true, fragmentHeaderLen+n)
+		buff := p.GetDataBuffer()
+
+		buff[0] = byte(flags)
+		buff[1] = byte(index >> 16)
+		buff[2] = byte(index >> 8)
+		buff[3] = byte(index)
+
+		copy(buff[fragmentHeaderLen:], msg[offset:offset+n])
+		p.SetDataLength(fragmentHeaderLen + n)
+
+		offset += n
+
+		err := i.send(p)
+		if err != nil {
+			return err
+		}
+		if flags&fragmentLast != 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package lrmp_test
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+type messageRecorder struct {
+	sync.Mutex
+	messages [][]byte
+}
+
+func (m *messageRecorder) ProcessMessage(source lrmp.Identity, msg []byte) {
+	m.Lock()
+	defer m.Unlock()
+	m.messages = append(m.messages, msg)
+}
+
+func (m *messageRecorder) ProcessEvent(event int, data interface{}) {}
+
+func (m *messageRecorder) count() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.messages)
+}
+
+func TestMessagesOverLossyLink(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Loss: 0.1, Delay: 5 * time.Millisecond})
+
+	p := n.profile()
+	p.SendWindowSize = 256
+	a := n.join("10.0.0.1", p)
+
+	m := &messageRecorder{}
+	p = n.profile()
+	p.Handler = lrmp.NewMessageAssembler(m)
+	p.RcvWindowSize = 256
+	n.join("10.0.0.2", p)
+
+	rnd := rand.New(rand.NewSource(1))
+
+	var sent [][]byte
+
+	for _, size := range []int{1, 20000, 0, 1500, 100} {
+		msg := make([]byte, size)
+		rnd.Read(msg)
+		sent = append(sent, msg)
+
+		if err := a.SendMessage(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !n.runUntil(time.Minute, func() bool { return m.count() == len(sent) }) {
+		t.Fatalf("received %d messages", m.count())
+	}
+
+	for i, msg := range m.messages {
+		if !bytes.Equal(msg, sent[i]) {
+			t.Fatalf("message %d of %d bytes received with %d bytes", i, len(sent[i]), len(msg))
+		}
+	}
+}
+
+func TestOrderedHandlersNeedOrderedProfile(t *testing.T) {
+	g := vnet.NewGroup(1)
+
+	handlers := []lrmp.EventHandler{lrmp.NewMessageAssembler(&messageRecorder{}), lrmp.NewStreamReceiver()}
+
+	for _, h := range handlers {
+		p := lrmp.NewProfile()
+		p.Handler = h
+		p.Ordered = false
+
+		if _, err := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.1")), 1, *p); err == nil {
+			t.Fatalf("%T accepted without ordered delivery", h)
+		}
+
+		p.Ordered = true
+
+		l, err := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.1")), 1, *p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p.Ordered = false
+
+		if l.SetProfile(*p) == nil {
+			t.Fatalf("%T accepted after turning the ordered delivery off", h)
+		}
+		l.Stop()
+	}
+}
@@ -1,25 +1,14 @@
 package lrmp
 
-import (
-	"fmt"
-	"golang.org/x/net/ipv4"
-	"math/rand"
-	"net"
-)
-
 type msession struct {
-	socket  *ipv4.PacketConn
+	socket  Transport
 	impl    *impl
 	packets int
 	bytes   int64
-	gaddr   *net.UDPAddr
 }
 
-// enable the following to test recovery on reliable networks
-const DropPackets = true
-
-func newSession(socket *ipv4.PacketConn, impl *impl, gaddr *net.UDPAddr) *msession {
-	s := msession{socket: socket, impl: impl, gaddr: gaddr}
+func newSession(socket Transport, impl *impl) *msession {
+	s := msession{socket: socket, impl: impl}
 	return &s
 }
 
@@ -27,22 +16,17 @@ func (s *msession) start() {
 	go func() {
 		var buffer [maxPacketSize]byte
 		for {
-			n, _, addr, err := s.socket.ReadFrom(buffer[:])
+			n, addr, err := s.socket.ReadFrom(buffer[:])
 
-			if DropPackets && drop() {
-				//Logger.trace(this, "drop packet")
-				continue
+			if err != nil {
+				logDebug("reader exiting", err)
+				break
 			}
 
 			s.packets += 1
 			s.bytes += int64(n)
 
-			s.impl.parse(buffer[:n], n, addr.(*net.UDPAddr).IP)
-
-			if err != nil {
-				fmt.Println("reader existing")
-				break
-			}
+			s.impl.receive(buffer[:n], n, addr)
 		}
 	}()
 }
@@ -54,27 +38,32 @@ func (s *msession) stop() {
  * sends data to the session using the provided TTL.
  */
 func (s *msession) send(buf []byte, len int, ttl int) {
-	if DropPackets && drop() {
-		logDebug("drop packet")
-		return
-	}
-
-	s.socket.SetMulticastTTL(ttl)
-	s.socket.SetTTL(ttl)
-
-	_, err := s.socket.WriteTo(buf[:len], nil, s.gaddr)
+	_, err := s.socket.WriteTo(buf[:len], ttl)
 	if err != nil {
 		logError("unable to write to socket", err)
 	}
 }
 
-/*
- * just for simulation.
+/**
+ * stops the reception of the group if supported by the transport.
  */
-func drop() bool {
-	if rand.Intn(10) < 2 {
-		return true
-	} else {
-		return false
+func (s *msession) leaveGroup() {
+	if mt, ok := s.socket.(MembershipTransport); ok {
+		err := mt.LeaveGroup()
+		if err != nil {
+			logError("unable to leave group", err)
+		}
+	}
+}
+
+/**
+ * resumes the reception of the group after leaveGroup.
+ */
+func (s *msession) joinGroup() {
+	if mt, ok := s.socket.(MembershipTransport); ok {
+		err := mt.JoinGroup()
+		if err != nil {
+			logError("unable to join group", err)
+		}
 	}
 }
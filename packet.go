@@ -19,6 +19,8 @@ type Packet struct {
 	sender       Entity
 	rcvSendTime  time.Time
 	retransmit   bool
+	/* handed to the application, possibly out of order */
+	delivered bool
 }
 
 const padBit = 0x20
@@ -30,6 +32,24 @@ func (packet *Packet) GetMaxDataLength() int {
 	return packet.maxDataLen
 }
 
+/**
+ * returns the source of a received packet.
+ */
+func (packet *Packet) GetSource() Identity {
+	if packet.source == nil {
+		return Identity{}
+	}
+	return identityOf(packet.source)
+}
+
+/**
+ * returns the sequence number of a received reliable packet, e.g. to put
+ * back in order the packets of an unordered session.
+ */
+func (packet *Packet) GetSeqno() int64 {
+	return packet.seqno
+}
+
 func (packet *Packet) GetDataBuffer() []byte {
 	return packet.buff[packet.offset : packet.offset+packet.maxDataLen]
 }
@@ -42,7 +62,7 @@ func (packet *Packet) SetDataLength(length int) error {
 	return nil
 }
 
-func (p *Packet) appendSenderReport(whoami *sender) {
+func (p *Packet) appendSenderReport(whoami *sender, now time.Time) {
 
 	offset := p.offset
 	buff := p.buff
@@ -58,7 +78,7 @@ func (p *Packet) appendSenderReport(whoami *sender) {
 
 	offset += 4
 
-	intToByte(ntp32(nowMillis()), buff, offset)
+	intToByte(ntp32(unixMillis(now)), buff, offset)
 
 	offset += 4
 
@@ -83,7 +103,7 @@ func (p *Packet) appendSenderReport(whoami *sender) {
 	p.offset = offset
 }
 
-func (p *Packet) appendRRSelection(whoami *sender, prob int, period int) {
+func (p *Packet) appendRRSelection(whoami *sender, prob int, period int, now time.Time) {
 	offset := p.offset
 	buff := p.buff
 
@@ -98,7 +118,7 @@ func (p *Packet) appendRRSelection(whoami *sender, prob int, period int) {
 
 	offset += 4
 
-	intToByte(ntp32(nowMillis()), buff, offset)
+	intToByte(ntp32(unixMillis(now)), buff, offset)
 
 	offset += 4
 
@@ -130,7 +150,7 @@ func (p *Packet) appendRRSelection(whoami *sender, prob int, period int) {
 	p.offset = offset
 }
 
-func (p *Packet) appendReceiverReport(sender *sender, whoami *sender) {
+func (p *Packet) appendReceiverReport(sender *sender, whoami *sender, now time.Time) {
 	start := p.offset
 
 	offset := p.offset
@@ -153,7 +173,7 @@ func (p *Packet) appendReceiverReport(sender *sender, whoami *sender) {
 
 	offset += 4
 
-	delay := time.Now().Sub(sender.rrSelectTime)
+	delay := now.Sub(sender.rrSelectTime)
 
 	delayMS := millisToFixedPoint32(int(millis(delay)))
 
@@ -170,26 +190,38 @@ func (p *Packet) appendReceiverReport(sender *sender, whoami *sender) {
 
 	sender.rrAbsLost = absLost
 
+	/* loss rate in 1/128 */
+
 	if relativeLost > 0 {
 		expected := int(sender.maxseq - sender.rrMaxSeqno)
 
 		sender.rrMaxSeqno = sender.maxseq
 
 		if expected > relativeLost {
-			buff[offset] = byte((relativeLost << 8) / expected)
-			offset++
+			buff[offset] = byte((relativeLost << 7) / expected)
 		} else {
-			buff[offset] = byte(0xff)
-			offset++
+			buff[offset] = byte(0x7f)
 		}
 	} else {
 		buff[offset] = 0
-		offset++
 	}
 
+	/*
+	 * set the congestion flag if the packets not yet delivered exceed half
+	 * the receive window, i.e. those held behind a loss. A slow consumer
+	 * slows the reading instead, see OverflowBlock.
+	 */
+	congested := sender.undelivered() > (sender.cacheSize >> 1)
+
+	if congested {
+		buff[offset] |= 0x80
+	}
+
+	offset++
+
 	if isDebug() {
 		logDebug("send RR lost/rate:", absLost, "/",
-			float64(buff[offset-1])/256.0, " max/init:",
+			float64(buff[offset-1]&0x7f)/128.0, " congested:", congested, " max/init:",
 			sender.maxseq, "/", sender.startseq,
 			" packs/dup:", sender.packets, "/",
 			sender.duplicates)
@@ -220,8 +252,63 @@ func (p *Packet) appendReceiverReport(sender *sender, whoami *sender) {
 
 }
 
+/**
+ * appends the interarrival jitter of the sender, sent after the receiver
+ * report if the profile enables JitterReports. The RJ packet is an extension
+ * of the draft since its report format has no room for the jitter:
+ *
+ *   version, type 22 (8 bits) | scope (8 bits) | length 16 (16 bits)
+ *   source ID of the reporter (32 bits)
+ *   ID of the sender (32 bits)
+ *   interarrival jitter, NTP fixed point (32 bits)
+ */
+func (p *Packet) appendJitterReport(sender *sender, whoami *sender) {
+	offset := p.offset
+	buff := p.buff
+
+	buff[offset] = (byte)((VersionNumber << 6) | RJ_PT)
+	buff[offset+1] = byte(p.scope)
+
+	shortToByte(16, buff, offset+2)
+	intToByte(int(whoami.getID()), buff, offset+4)
+	intToByte(int(sender.getID()), buff, offset+8)
+	intToByte(millisToFixedPoint32(sender.getJitter()), buff, offset+12)
+
+	p.offset = offset + 16
+}
+
+/**
+ * appends a BYE packet carrying the last sequence number sent.
+ */
+func (p *Packet) appendBye(whoami *sender) {
+	offset := p.offset
+	buff := p.buff
+
+	buff[offset] = (byte)((VersionNumber << 6) | BYE_PT)
+	buff[offset+1] = byte(p.scope)
+
+	shortToByte(12, buff, offset+2)
+	intToByte(int(whoami.getID()), buff, offset+4)
+	intToByte(int(whoami.expected-1), buff, offset+8)
+
+	p.offset = offset + 12
+}
+
 const MTU = 1400
 
+/* the length of a receiver report and of a jitter report */
+const (
+	rrLength = 28
+	rjLength = 16
+)
+
+/**
+ * returns the number of bytes left in the buffer.
+ */
+func (p *Packet) room() int {
+	return len(p.buff) - p.offset
+}
+
 func NewPacket(reliable bool, length int) *Packet {
 
 	p := Packet{}
@@ -251,7 +338,7 @@ func NewPacket(reliable bool, length int) *Packet {
 	return &p
 }
 
-func newDataPacket(reliable bool, buff []byte, offset int, len int) *Packet {
+func newDataPacket(reliable bool, buff []byte, offset int, len int, now time.Time) *Packet {
 	p := Packet{}
 
 	p.buff = buff
@@ -272,12 +359,12 @@ func newDataPacket(reliable bool, buff []byte, offset int, len int) *Packet {
 	}
 
 	p.scope = int(buff[offset+1] & 0xff)
-	p.rcvSendTime = time.Now()
+	p.rcvSendTime = now
 
 	return &p
 }
 
-func (p *Packet) formatDataPacket(resend bool) int {
+func (p *Packet) formatDataPacket(resend bool, now time.Time) int {
 	p.retransmit = resend
 
 	var headerlen int
@@ -312,7 +399,7 @@ func (p *Packet) formatDataPacket(resend bool) int {
 		intToByte(int(p.source.getID()), buff, start+4)
 
 		if p.reliable {
-			timestamp := ntp32(nowMillis())
+			timestamp := ntp32(unixMillis(now))
 
 			intToByte(timestamp, buff, start+8)
 			intToByte(int(p.seqno), buff, start+12)
@@ -338,7 +425,7 @@ func (p *Packet) formatDataPacket(resend bool) int {
 	return len
 }
 
-func (p *Packet) appendNackReply(ev *lossEvent, whoami *sender, firstReply int, bitmReply uint32) {
+func (p *Packet) appendNackReply(ev *lossEvent, whoami *sender, firstReply int, bitmReply uint32, now time.Time) {
 	start := p.offset
 
 	offset := p.offset
@@ -364,7 +451,7 @@ func (p *Packet) appendNackReply(ev *lossEvent, whoami *sender, firstReply int,
 	/*
 	 * expressed in units of 1/65536 seconds (1/0x10000).
 	 */
-	delay := int(millis(time.Now().Sub(ev.rcvSendTime)))
+	delay := int(millis(now.Sub(ev.rcvSendTime)))
 
 	delay = millisToFixedPoint32(delay)
 
@@ -391,7 +478,7 @@ func (p *Packet) appendNackReply(ev *lossEvent, whoami *sender, firstReply int,
 	p.offset = offset
 }
 
-func (p *Packet) appendNack(ev *lossEvent) {
+func (p *Packet) appendNack(ev *lossEvent, now time.Time) {
 	start := p.offset
 
 	buff := p.buff
@@ -406,7 +493,7 @@ func (p *Packet) appendNack(ev *lossEvent) {
 
 	offset += 4
 
-	intToByte(ntp32(nowMillis()), buff, offset)
+	intToByte(ntp32(unixMillis(now)), buff, offset)
 
 	offset += 4
 
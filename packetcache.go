@@ -25,6 +25,18 @@ func newPacketCache(size int) packetCache {
 	return pc
 }
 
+/**
+ * returns the size of a cache holding at least size packets.
+ */
+func roundCacheSize(size int) int {
+	n := 1
+
+	for n < size {
+		n = n << 1
+	}
+	return n
+}
+
 /**
  * returns the maximum size of the cache.
  */
@@ -86,6 +98,28 @@ func (pc *packetCache) removeBySeqNo(seqno int64) {
 	}
 }
 
+/**
+ * returns a cache of the given size holding the packets of this one. When
+ * the cache shrinks, the most recent packet is kept in a shared slot.
+ */
+func (pc *packetCache) resize(size int) packetCache {
+	cache := newPacketCache(size)
+
+	for _, p := range pc.buffer {
+		if p == nil {
+			continue
+		}
+
+		q := cache.buffer[int(p.seqno)&cache.mask]
+
+		if q == nil || diff32(p.seqno, q.seqno) > 0 {
+			cache.addPacket(p)
+		}
+	}
+
+	return cache
+}
+
 /**
  * clear all cached packets
  */
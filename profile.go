@@ -1,5 +1,10 @@
 package lrmp
 
+import (
+	"errors"
+	"strconv"
+)
+
 const (
 	LossAllowed            = 1
 	LimitedLoss            = 2
@@ -13,16 +18,47 @@ const (
 )
 
 type Profile struct {
-	Handler            EventHandler
-	sendWindowSize     int
-	rcvWindowSize      int
-	minRate            int
-	maxRate            int
-	sendRepair         bool
-	Ordered            bool
-	Reliability        int
-	Throughput         int
-	rcvReportSelection int
+	Handler EventHandler
+	Clock   Clock
+	/* number of packets kept by the sender for the repairs, at least 32 */
+	SendWindowSize int
+	/* number of packets kept by a receiver per sender for reordering and repairs */
+	RcvWindowSize int
+	/* bounds of the transmission rate in kilo bits/sec */
+	MinRate    int
+	MaxRate    int
+	SendRepair bool
+	/* deliver the reliable packets in sequence rather than on arrival, needed by MessageAssembler and StreamReceiver */
+	Ordered     bool
+	Reliability int
+	Throughput  int
+	/* how the receivers sending reports are selected */
+	RcvReportSelection int
+	/* interval of the sender reports in millis */
+	SenderReportInterval int
+	/* interval of the selection of receivers for reports in millis */
+	RcvReportSelInterval int
+	/* number of NACKs for a loss before giving up */
+	MaxTries int
+	/* silence in millis after which a receiver or a sender is forgotten */
+	RcvDropTime int
+	SndDropTime int
+	/* number of entities above which silent receivers are forgotten sooner */
+	MaxSrc int
+	/* time in millis during which a closing sender keeps answering NACKs */
+	LingerTime int
+	/* number of reliable packets protected by one FEC packet, 0 disables FEC */
+	FecBlockSize int
+	/* ignore the congestion signals of the slowest receivers when the send queue stays full */
+	IgnoreSlowReceivers bool
+	/* loss rate in percent above which a receiver leaves the session and rejoins later, 0 disables */
+	EvictionLossRate int
+	/*
+	 * send the jitter of the received data along with the receiver reports,
+	 * in an RJ packet which is not in the draft. Off by default since other
+	 * LRMP implementations may not skip it.
+	 */
+	JitterReports bool
 }
 
 func (profile *Profile) lossAllowed() bool {
@@ -30,11 +66,80 @@ func (profile *Profile) lossAllowed() bool {
 }
 
 func NewProfile() *Profile {
-	p := Profile{sendWindowSize: 64, rcvWindowSize: 64, minRate: 8, maxRate: 64, sendRepair: true, Ordered: true, Reliability: NoLoss, Throughput: AdaptedThroughput}
-	p.rcvReportSelection = RandomReceiverReport
+	p := Profile{SendWindowSize: 64, RcvWindowSize: 64, MinRate: 8, MaxRate: 64, SendRepair: true, Ordered: true, Reliability: NoLoss, Throughput: AdaptedThroughput, Clock: SystemClock}
+	p.RcvReportSelection = RandomReceiverReport
+	p.SenderReportInterval = 4000
+	p.RcvReportSelInterval = 30000
+	p.MaxTries = MaxTries
+	p.RcvDropTime = rcvDropTime
+	p.SndDropTime = sndDropTime
+	p.MaxSrc = maxSrc
+	p.LingerTime = 5000
 	return &p
 }
 
+/**
+ * checks the profile, e.g. one built from NewProfile and changed by the
+ * application. Returns the first invalid setting found.
+ */
+func (profile *Profile) Validate() error {
+	_, needsOrder := profile.Handler.(orderedHandler)
+
+	switch {
+	case profile.SendWindowSize < 32:
+		return errors.New("send window size must be at least 32")
+	case profile.RcvWindowSize < 32:
+		return errors.New("receive window size must be at least 32")
+	case profile.MinRate <= 0:
+		return errors.New("minimum rate must be positive")
+	case profile.MaxRate < profile.MinRate:
+		return errors.New("maximum rate must not be less than the minimum rate")
+	case profile.Reliability < LossAllowed || profile.Reliability > NoLoss:
+		return errors.New("invalid reliability " + strconv.Itoa(profile.Reliability))
+	case profile.Throughput < BestEffort || profile.Throughput > AdaptedThroughput:
+		return errors.New("invalid throughput " + strconv.Itoa(profile.Throughput))
+	case profile.RcvReportSelection < NoReceiverReport || profile.RcvReportSelection > PeriodicReceiverReport:
+		return errors.New("invalid receiver report selection " + strconv.Itoa(profile.RcvReportSelection))
+	case profile.SenderReportInterval <= 0:
+		return errors.New("sender report interval must be positive")
+	case profile.RcvReportSelInterval <= 0:
+		return errors.New("receiver report selection interval must be positive")
+	case profile.MaxTries <= 0:
+		return errors.New("max tries must be positive")
+	case profile.RcvDropTime <= 0:
+		return errors.New("receiver drop time must be positive")
+	case profile.SndDropTime < profile.RcvDropTime:
+		return errors.New("sender drop time must not be less than the receiver drop time")
+	case profile.MaxSrc <= 0:
+		return errors.New("max sources must be positive")
+	case profile.LingerTime < 0:
+		return errors.New("linger time must not be negative")
+	case profile.FecBlockSize < 0 || profile.FecBlockSize > 255:
+
+		/* the FEC block size is carried in 8 bits */
+
+		return errors.New("FEC block size must be between 0 and 255")
+	case profile.EvictionLossRate < 0 || profile.EvictionLossRate > 100:
+		return errors.New("eviction loss rate must be between 0 and 100")
+	case needsOrder && !profile.Ordered:
+		return errors.New("the handler needs an ordered delivery")
+	}
+	return nil
+}
+
+/**
+ * implemented by the handlers which need the packets of each source in
+ * sequence, e.g. to reassemble messages.
+ */
+type orderedHandler interface {
+	needsOrder()
+}
+
+/**
+ * EventHandler receives the data and events of the session. The data of an
+ * event is the Event of the given type, e.g. a *SequenceError for
+ * UNRECOVERABLE_SEQUENCE_ERROR.
+ */
 type EventHandler interface {
 	ProcessData(p *Packet)
 	ProcessEvent(event int, data interface{})
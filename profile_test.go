@@ -0,0 +1,175 @@
+package lrmp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+func TestProfileValidation(t *testing.T) {
+	invalid := map[string]func(p *lrmp.Profile){
+		"send window":      func(p *lrmp.Profile) { p.SendWindowSize = 16 },
+		"receive window":   func(p *lrmp.Profile) { p.RcvWindowSize = 0 },
+		"min rate":         func(p *lrmp.Profile) { p.MinRate = 0 },
+		"max rate":         func(p *lrmp.Profile) { p.MaxRate = p.MinRate - 1 },
+		"reliability":      func(p *lrmp.Profile) { p.Reliability = 99 },
+		"throughput":       func(p *lrmp.Profile) { p.Throughput = -1 },
+		"report selection": func(p *lrmp.Profile) { p.RcvReportSelection = 99 },
+		"sender report":    func(p *lrmp.Profile) { p.SenderReportInterval = 0 },
+		"selection":        func(p *lrmp.Profile) { p.RcvReportSelInterval = 0 },
+		"max tries":        func(p *lrmp.Profile) { p.MaxTries = 0 },
+		"receiver drop":    func(p *lrmp.Profile) { p.RcvDropTime = 0 },
+		"sender drop":      func(p *lrmp.Profile) { p.SndDropTime = p.RcvDropTime - 1 },
+		"max sources":      func(p *lrmp.Profile) { p.MaxSrc = 0 },
+		"linger":           func(p *lrmp.Profile) { p.LingerTime = -1 },
+		"fec block":        func(p *lrmp.Profile) { p.FecBlockSize = 256 },
+		"eviction":         func(p *lrmp.Profile) { p.EvictionLossRate = 101 },
+	}
+
+	if err := lrmp.NewProfile().Validate(); err != nil {
+		t.Fatalf("default profile: %v", err)
+	}
+
+	g := vnet.NewGroup(1)
+
+	for name, change := range invalid {
+		p := lrmp.NewProfile()
+		change(p)
+
+		if p.Validate() == nil {
+			t.Fatalf("invalid %s accepted", name)
+		}
+		if _, err := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.1")), 1, *p); err == nil {
+			t.Fatalf("session created with an invalid %s", name)
+		}
+	}
+}
+
+func TestInvalidChangeKeepsProfile(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", n.profile())
+
+	before := a.Profile()
+
+	if a.SetRate(100, 10) == nil {
+		t.Fatal("max rate below min rate accepted")
+	}
+	if a.SetWindowSize(8, 64) == nil {
+		t.Fatal("send window of 8 accepted")
+	}
+
+	p := a.Profile()
+	p.MaxTries = 0
+
+	if a.SetProfile(p) == nil {
+		t.Fatal("max tries of 0 accepted")
+	}
+
+	if after := a.Profile(); after.MinRate != before.MinRate || after.SendWindowSize != before.SendWindowSize || after.MaxTries != before.MaxTries {
+		t.Fatalf("profile changed from %+v to %+v", before, after)
+	}
+}
+
+/* the sender keeps its sequence space across the changes */
+func TestLiveReconfiguration(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.joinTTL("10.0.0.1", 63, n.profile())
+
+	r := &recorder{}
+	p := n.profile()
+	p.Handler = r
+	n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 10)
+	n.runUntil(10*time.Second, func() bool { return r.count() == 10 })
+
+	if err := a.SetTTL(15); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.TTL() != 15 {
+		t.Fatalf("ttl %d after SetTTL(15)", a.TTL())
+	}
+	if domains := a.Domains(); len(domains) != 1 || domains[0].Scope != 15 {
+		t.Fatalf("domains %+v for a ttl of 15", domains)
+	}
+
+	if err := a.SetRate(16, 128); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetWindowSize(128, 128); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetReliability(lrmp.LossAllowed); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := a.Profile(); p.MinRate != 16 || p.MaxRate != 128 || p.SendWindowSize != 128 || p.RcvWindowSize != 128 || p.Reliability != lrmp.LossAllowed {
+		t.Fatalf("profile %+v after the changes", p)
+	}
+
+	sendPackets(t, a, 10, 10)
+
+	if !n.runUntil(10*time.Second, func() bool { return r.count() == 20 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 20)
+
+	r.Lock()
+	defer r.Unlock()
+
+	for i, seqno := range r.seqnos {
+		if seqno != r.seqnos[0]+int64(i) {
+			t.Fatalf("seqnos %v", r.seqnos)
+		}
+	}
+}
+
+/**
+ * an endpoint of a group whose address limits the scope to the link.
+ */
+type linkScoped struct {
+	*vnet.Endpoint
+}
+
+func (linkScoped) MaxScope() int {
+	return 1
+}
+
+func TestInvalidTTL(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	for _, ttl := range []int{-1, 256} {
+		if _, err := lrmp.NewLrmpWithTransport(n.group.Join(net.ParseIP("10.0.0.2")), ttl, *n.profile()); err == nil {
+			t.Fatalf("session created with a ttl of %d", ttl)
+		}
+	}
+	if _, err := lrmp.NewLrmpWithTransport(linkScoped{n.group.Join(net.ParseIP("10.0.0.2"))}, 2, *n.profile()); err == nil {
+		t.Fatal("session created with a ttl beyond the scope of the group")
+	}
+
+	a := n.joinTTL("10.0.0.1", 63, n.profile())
+
+	for _, ttl := range []int{-1, 256} {
+		if a.SetTTL(ttl) == nil {
+			t.Fatalf("ttl of %d accepted", ttl)
+		}
+	}
+	if a.TTL() != 63 {
+		t.Fatalf("ttl %d after invalid changes", a.TTL())
+	}
+
+	b := n.joinWith(linkScoped{n.group.Join(net.ParseIP("10.0.0.3"))}, 1, n.profile())
+
+	if b.SetTTL(2) == nil {
+		t.Fatal("ttl beyond the scope of the group accepted")
+	}
+	if err := b.SetTTL(0); err != nil || b.TTL() != 0 {
+		t.Fatalf("ttl %d after SetTTL(0): %v", b.TTL(), err)
+	}
+}
@@ -12,11 +12,20 @@ type recovery struct {
 	random rand.Rand
 	task   *timerTask
 	dummy  *Packet
+	/* replaced, e.g. by a TTL change, a timer already due does nothing */
+	stopped bool
 }
 
 const MaxTries = 8
 
 func (r *recovery) handleTimerTask(data interface{}, thetime time.Time) {
+	r.cxt.lock()
+	defer r.cxt.unlock()
+
+	if r.stopped {
+		return
+	}
+
 	r.task = nil
 
 	if isDebug() {
@@ -73,7 +82,7 @@ func (r *recovery) handleTimerTask(data interface{}, thetime time.Time) {
 			r.cxt.lrmp.handleSyncError(ev.source, SenderGone)
 			r.domain.lossTab.Remove(elem)
 			continue
-		} else if ev.nackCount >= MaxTries {
+		} else if int(ev.nackCount) >= r.cxt.profile.MaxTries {
 			r.cxt.lrmp.handleSyncError(ev.source, MaxTriesReached)
 			r.domain.lossTab.Remove(elem)
 			continue
@@ -116,6 +125,15 @@ func (r *recovery) handleTimerTask(data interface{}, thetime time.Time) {
 			 * schedule period for this loss. So send a NACK.
 			 * One try for lower domains and MaxTries for all domains.
 			 */
+			if s.fecPending(ev.low, thetime) {
+
+				/* the parity may rebuild it, wait for the rest of the block */
+
+				r.nackTimer(ev)
+
+				break
+			}
+
 			if r.dummy == nil {
 				r.dummy = NewPacket(false, 64)
 				r.dummy.sender = r.cxt.whoami
@@ -124,7 +142,7 @@ func (r *recovery) handleTimerTask(data interface{}, thetime time.Time) {
 			r.dummy.scope = ev.scope
 			r.dummy.offset = 0
 
-			r.dummy.appendNack(ev)
+			r.dummy.appendNack(ev, thetime)
 
 			if isDebug() {
 				logDebug("send NACK ", ev)
@@ -132,7 +150,7 @@ func (r *recovery) handleTimerTask(data interface{}, thetime time.Time) {
 
 			r.cxt.lrmp.sendControlPacket(r.dummy, ev.scope)
 
-			ev.domain.stats.nack++
+			ev.domain.stats.Nack++
 			ev.domain.failedNack++
 			ev.rcvSendTime = thetime
 
@@ -153,7 +171,7 @@ func (r *recovery) handleTimerTask(data interface{}, thetime time.Time) {
 
 func newRecovery(ttl int, cxt *Context) *recovery {
 
-	domain := newDomain(ttl)
+	domain := newDomain(ttl, cxt.clock)
 	r := recovery{cxt: cxt, ttl: ttl, domain: domain}
 
 	/* the loss table is shared */
@@ -162,33 +180,39 @@ func newRecovery(ttl int, cxt *Context) *recovery {
 	domain.lossHistory = &lossHistory{}
 
 	if ttl > 63 {
-		domain.child = newDomain(63)
+		domain.child = newDomain(63, cxt.clock)
 		domain.child.lossTab = domain.lossTab
 		domain.child.lossHistory = domain.lossHistory
 		domain.setChild(domain.child)
 		domain = domain.child
 	}
 	if ttl > 47 {
-		domain.child = newDomain(47)
+		domain.child = newDomain(47, cxt.clock)
 		domain.child.lossTab = domain.lossTab
 		domain.child.lossHistory = domain.lossHistory
 		domain.setChild(domain.child)
 		domain = domain.child
 	}
 	if ttl > 15 {
-		domain.child = newDomain(15)
+		domain.child = newDomain(15, cxt.clock)
 		domain.child.lossTab = domain.lossTab
 		domain.child.lossHistory = domain.lossHistory
 		domain.setChild(domain.child)
 		domain = domain.child
 	}
 
+	/* the lookups begin from the lowest domain */
+
+	r.domain = domain
+
 	return &r
 }
 
 func (r *recovery) stop() {
+	r.stopped = true
+
 	if r.task != nil {
-		timer.recallTimer(r.task)
+		r.cxt.timer.recallTimer(r.task)
 		r.task = nil
 		r.domain.lossTab.clear()
 	}
@@ -198,7 +222,7 @@ func (r *recovery) processNack(received *lossEvent) {
 	dc := r.lookupDomain(received.scope)
 
 	received.domain = dc
-	dc.stats.nack++
+	dc.stats.Nack++
 
 	/*
 	 * there are three cases:
@@ -229,7 +253,7 @@ func (r *recovery) processNack(received *lossEvent) {
 			}
 		}
 		if event.contains(received) {
-			slice := dc.stats.mrtt >> 2
+			slice := dc.mrtt >> 2
 
 			if received.source.interval < 200 {
 				slice += received.source.interval
@@ -237,10 +261,10 @@ func (r *recovery) processNack(received *lossEvent) {
 				slice += 200
 			}
 			if int(millis(received.rcvSendTime.Sub(event.rcvSendTime))) <= slice {
-				dc.stats.dupNack++
+				dc.stats.DupNack++
 
 				if isDebug() {
-					logDebug("Dup nack: ", slice, "/", dc.stats.mrtt)
+					logDebug("Dup nack: ", slice, "/", dc.mrtt)
 				}
 			}
 
@@ -251,7 +275,7 @@ func (r *recovery) processNack(received *lossEvent) {
 	/* ignore duplicates */
 
 	if dc.isDuplicate(received) {
-		dc.stats.dupNack++
+		dc.stats.DupNack++
 
 		return
 	}
@@ -271,7 +295,7 @@ func (r *recovery) processNack(received *lossEvent) {
 		 * at the top level, don't send repair if we are not the original sender.
 		 * check cache now, since we may send recently received repairs.
 		 */
-		if dc.parent != nil && r.cxt.profile.sendRepair {
+		if dc.parent != nil && r.cxt.profile.SendRepair {
 
 			/* make a copy to keep the original intact (kept in history) */
 
@@ -313,7 +337,7 @@ func (r *recovery) processNack(received *lossEvent) {
 func (r *recovery) processNackReply(responder Entity, ev *lossEvent, delay int) {
 	dc := r.lookupDomain(ev.scope)
 
-	dc.stats.nackReply++
+	dc.stats.NackReply++
 
 	/*
 	 * if we are the original sender, nothing to do.
@@ -344,7 +368,7 @@ func (r *recovery) processNackReply(responder Entity, ev *lossEvent, delay int)
 
 		/* NTP offset is subtracted */
 
-		rtt := ntp32(nowMillis()) - ev.timestamp - delay
+		rtt := ntp32(unixMillis(r.cxt.clock.Now())) - ev.timestamp - delay
 
 		rtt = fixedPoint32ToMillis(rtt)
 		responder.setRTT(rtt)
@@ -402,6 +426,13 @@ func (r *recovery) handleLoss(s *sender) {
 		return
 	}
 
+	/*
+	 * try the redundant data first, no need to NACK what FEC can rebuild.
+	 */
+	if len(s.fecBlocks) > 0 && r.cxt.lrmp.fecRecover(s) && diff32(s.maxseq, s.expected) < 0 {
+		return
+	}
+
 	diff := diff32(s.maxseq, s.expected)
 
 	if diff > s.cacheSize {
@@ -466,6 +497,41 @@ func (r *recovery) lookupDomain(ttl int) *domain {
 	return nil
 }
 
+/**
+ * returns the domains from the top level domain down to the lowest one.
+ */
+func (r *recovery) domains() []*domain {
+	d := r.domain
+
+	for d.parent != nil {
+		d = d.parent
+	}
+
+	var domains []*domain
+
+	for ; d != nil; d = d.child {
+		domains = append(domains, d)
+	}
+
+	return domains
+}
+
+/**
+ * returns the smallest domain which covers the given scope, or the top level
+ * domain.
+ */
+func (r *recovery) scopeDomain(ttl int) *domain {
+	domains := r.domains()
+
+	for i := len(domains) - 1; i > 0; i-- {
+		if ttl <= domains[i].scope {
+			return domains[i]
+		}
+	}
+
+	return domains[0]
+}
+
 func (r *recovery) lookup(s *sender, reporter Entity) *lossEvent {
 	return r.domain.lossTab.lookup(s, reporter)
 }
@@ -477,7 +543,7 @@ func (r *recovery) getDomain() *domain {
 	for d := r.domain; d != nil; d = d.parent {
 		d.checkState()
 
-		if d.stats.enabled {
+		if d.stats.Enabled {
 			return d
 		}
 	}
@@ -499,7 +565,7 @@ func (r *recovery) getDomain() *domain {
  * bound since responders may use it to schedule the resend.
  */
 func (r *recovery) nackTimer(ev *lossEvent) {
-	d := (ev.domain.stats.mrtt << ev.nackCount) >> 3
+	d := (ev.domain.mrtt << ev.nackCount) >> 3
 
 	/*
 	 * at the moment we are rather conservative, but at some later time
@@ -521,10 +587,10 @@ func (r *recovery) nackTimer(ev *lossEvent) {
 		d = int(float64(d) * (1.0 + rand.Float64()))
 	}
 	if isDebug() {
-		logDebug("NACK timer=", d, " #", ev.nackCount, " ", ev.domain.stats.getRTT(), "/", ev.source.interval, "@", ev.scope)
+		logDebug("NACK timer=", d, " #", ev.nackCount, " ", ev.domain.mrtt>>3, "/", ev.source.interval, "@", ev.scope)
 	}
 
-	ev.timeoutTime = time.Now().Add(time.Duration(d) * time.Millisecond)
+	ev.timeoutTime = addMillis(r.cxt.clock.Now(), d)
 }
 
 func (r *recovery) startTimer() {
@@ -541,16 +607,18 @@ func (r *recovery) startTimer() {
 
 	if !future.IsZero() {
 		if r.task != nil {
-			timer.recallTimer(r.task)
+			r.cxt.timer.recallTimer(r.task)
 		}
 
-		millis := millis(future.Sub(time.Now()))
+		millis := millis(future.Sub(r.cxt.clock.Now()))
+
+		/* less than a millisecond left would fire before the event is due */
 
-		if millis < 0 {
+		if millis < 1 {
 			millis = 1
 		}
 
-		r.task = timer.registerTimer(int(millis), r, nil)
+		r.task = r.cxt.timer.registerTimer(int(millis), r, nil)
 
 		if isDebug() {
 			logDebug("Next timeout=", millis, " events: ", r.domain.lossTab.Len())
@@ -561,21 +629,21 @@ func (r *recovery) startTimer() {
 func (r *recovery) heardRepair(p *Packet, dup bool) {
 	dc := r.lookupDomain(p.scope)
 
-	dc.stats.repairPackets++
-	dc.stats.repairBytes += int64(p.datalen)
+	dc.stats.RepairPackets++
+	dc.stats.RepairBytes += int64(p.datalen)
 
 	if p.sender != p.source {
-		dc.stats.thirdPartyRepairs++
+		dc.stats.ThirdPartyRepairs++
 	}
 
 	source := p.source.(*sender)
 
 	if dup {
-		dc.stats.dupPackets++
-		dc.stats.dupBytes += int64(p.datalen)
+		dc.stats.DupPackets++
+		dc.stats.DupBytes += int64(p.datalen)
 
 		if p.sender != p.source {
-			dc.stats.thirdPartyDuplicates++
+			dc.stats.ThirdPartyDuplicates++
 		}
 		if isDebug() {
 			if p.sender == source {
@@ -624,7 +692,7 @@ func (r *recovery) heardRepair(p *Packet, dup bool) {
 				event.nextAction = DelayAndStay
 			}
 		}
-		if !dc.stats.enabled {
+		if !dc.stats.Enabled {
 			dc.enable()
 		} else {
 			dc.failedNack = 0
@@ -670,10 +738,10 @@ func (r *recovery) resend(ev *lossEvent) {
 		reply.scope = ev.scope
 		reply.offset = 0
 
-		reply.appendNackReply(ev, r.cxt.whoami, int(firstSent), bitsSent)
+		reply.appendNackReply(ev, r.cxt.whoami, int(firstSent), bitsSent, r.cxt.clock.Now())
 		r.cxt.lrmp.sendControlPacket(reply, ev.scope)
 
-		ev.domain.stats.nackReply++
+		ev.domain.stats.NackReply++
 	}
 }
 
@@ -703,7 +771,7 @@ func (r *recovery) resendBySeqno(seqno int64, ev *lossEvent) bool {
 }
 
 func (r *recovery) resendTimer(ev *lossEvent) {
-	d := ev.domain.stats.mrtt >> 3
+	d := ev.domain.mrtt >> 3
 
 	d = int(float64(d) * (1.0 + rand.Float64()))
 
@@ -716,10 +784,10 @@ func (r *recovery) resendTimer(ev *lossEvent) {
 		d += 200
 	}
 	if isDebug() {
-		logDebug("resendTimer=", d, " ", ev.domain.stats.mrtt, "/", ev.source.interval)
+		logDebug("resendTimer=", d, " ", ev.domain.mrtt, "/", ev.source.interval)
 	}
 
-	ev.timeoutTime = addMillis(time.Now(), d)
+	ev.timeoutTime = addMillis(r.cxt.clock.Now(), d)
 }
 func (r *recovery) goUp(ev *lossEvent) {
 	if ev.domain.parent != nil && ev.scope < ev.source.distance {
@@ -0,0 +1,336 @@
+package lrmp_test
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+func TestRecoveryFromLoss(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Loss: 0.1, Delay: 5 * time.Millisecond})
+
+	/* large enough for the repairs and NACKs lost too */
+
+	p := n.profile()
+	p.SendWindowSize = 256
+	p.RcvWindowSize = 256
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	p.SendWindowSize = 256
+	p.RcvWindowSize = 256
+	b := n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 200)
+
+	if !n.runUntil(60*time.Second, func() bool { return r.count() == 200 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 200)
+
+	if d := b.Domains()[0]; d.Nack == 0 || d.RepairPackets == 0 {
+		t.Fatalf("recovered without NACK or repair: %+v", d)
+	}
+	if len(r.eventTypes()) != 2 {
+		t.Fatalf("events %v, want MEMBER_JOINED and MEMBER_SENDER only", r.eventTypes())
+	}
+}
+
+/*
+ * c and d lose the same packets, one NACK is enough and b, which is closer
+ * than the sender, repairs in a lower domain.
+ */
+func TestNackSuppressionAndThirdPartyRepair(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	addrs := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	for _, from := range addrs[2:] {
+		n.group.SetLink(net.ParseIP(addrs[0]), net.ParseIP(from), vnet.LinkConfig{Delay: 100 * time.Millisecond})
+		n.group.SetLink(net.ParseIP(from), net.ParseIP(addrs[0]), vnet.LinkConfig{Delay: 100 * time.Millisecond})
+	}
+
+	a := n.joinTTL(addrs[0], 63, n.profile())
+
+	var recorders []*recorder
+	var sessions []*lrmp.Lrmp
+
+	for _, addr := range addrs[1:] {
+		r := &recorder{}
+		p := n.profile()
+		p.Handler = r
+		recorders = append(recorders, r)
+		sessions = append(sessions, n.joinTTL(addr, 63, p))
+	}
+
+	sendPackets(t, a, 0, 10)
+	n.runUntil(10*time.Second, func() bool { return recorders[2].count() == 10 })
+
+	/* the link from the sender to c and d drops 10 packets */
+
+	for _, to := range addrs[2:] {
+		n.group.SetLink(net.ParseIP(addrs[0]), net.ParseIP(to), vnet.LinkConfig{Delay: 100 * time.Millisecond, Loss: 1})
+	}
+	sendPackets(t, a, 10, 10)
+	n.runUntil(5*time.Second, func() bool { return recorders[0].count() == 20 })
+
+	for _, to := range addrs[2:] {
+		n.group.SetLink(net.ParseIP(addrs[0]), net.ParseIP(to), vnet.LinkConfig{Delay: 100 * time.Millisecond})
+	}
+	sendPackets(t, a, 20, 10)
+
+	done := func() bool {
+		return recorders[0].count() == 30 && recorders[1].count() == 30 && recorders[2].count() == 30
+	}
+	if !n.runUntil(60*time.Second, done) {
+		t.Fatalf("received %d %d %d packets", recorders[0].count(), recorders[1].count(), recorders[2].count())
+	}
+	for _, r := range recorders {
+		checkInOrder(t, r, 30)
+	}
+
+	thirdParty := 0
+
+	for _, l := range sessions[1:] {
+		for _, d := range l.Domains() {
+			thirdParty += d.ThirdPartyRepairs
+		}
+	}
+	if thirdParty == 0 {
+		t.Fatal("no repair from a receiver")
+	}
+
+	/*
+	 * without suppression c and d would both NACK in the two lowest
+	 * domains, at most once each if their timers fire together.
+	 */
+	nacks := 0
+
+	for _, m := range sessions[0].Members().Receivers {
+		nacks += m.Nacks
+	}
+	if nacks == 0 || nacks > 3 {
+		t.Fatalf("b heard %d NACKs", nacks)
+	}
+}
+
+/* the lost packets are no longer in the send window when the loss is detected */
+func TestUnrecoverableLoss(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	p := n.profile()
+	p.SendWindowSize = 32
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	p.RcvWindowSize = 32
+	p.MaxTries = 2
+	n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 10)
+	n.runUntil(10*time.Second, func() bool { return r.count() == 10 })
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{Loss: 1})
+	sendPackets(t, a, 10, 100)
+	n.runUntil(60*time.Second, func() bool { return a.QueueLen() == 0 })
+	n.run(time.Second)
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{})
+	sendPackets(t, a, 110, 10)
+
+	last := func() bool {
+		data := r.received()
+		return data[len(data)-1] == "119"
+	}
+	if !n.runUntil(60*time.Second, last) {
+		t.Fatalf("received %v", r.received())
+	}
+
+	data := r.received()
+	if len(data) >= 120 {
+		t.Fatalf("received %d packets", len(data))
+	}
+	for i := 1; i < len(data); i++ {
+		prev, _ := strconv.Atoi(data[i-1])
+		next, _ := strconv.Atoi(data[i])
+		if next <= prev {
+			t.Fatalf("%d delivered after %d", next, prev)
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	/*
+	 * the continuous losses are reported once, just before the packet which
+	 * follows them. The first ones follow "9".
+	 */
+
+	var errors []*lrmp.SequenceError
+
+	for i, e := range r.events {
+		if se, ok := e.(*lrmp.SequenceError); ok {
+			if r.at[i] == len(r.seqnos) || r.seqnos[r.at[i]] != se.Last+1 {
+				t.Fatalf("sequence error %+v after %d packets", se, r.at[i])
+			}
+			errors = append(errors, se)
+		}
+	}
+	if len(errors) == 0 {
+		t.Fatal("no sequence error")
+	}
+	if se := errors[0]; se.First != r.seqnos[9]+1 || se.Last != r.seqnos[10]-1 {
+		t.Fatalf("lost #%d to #%d, want #%d to #%d", se.First, se.Last, r.seqnos[9]+1, r.seqnos[10]-1)
+	}
+}
+
+/*
+ * the send window shrinks while the receiver has lost the packets, and its
+ * TTL changes during the recovery. The packets sent before the change are
+ * still repaired.
+ */
+func TestReconfigureDuringRecovery(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	p := n.profile()
+	p.SendWindowSize = 256
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	p.RcvWindowSize = 256
+	b := n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 10)
+	n.runUntil(10*time.Second, func() bool { return r.count() == 10 })
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{Loss: 1})
+	sendPackets(t, a, 10, 100)
+	n.runUntil(60*time.Second, func() bool { return a.QueueLen() == 0 })
+
+	if err := a.SetWindowSize(32, 32); err != nil {
+		t.Fatal(err)
+	}
+
+	n.group.SetLink(net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), vnet.LinkConfig{})
+	sendPackets(t, a, 110, 10)
+
+	/* the recovery domains are rebuilt while the timers run */
+
+	go func() {
+		for i := 0; i < 20; i++ {
+			if err := b.SetTTL(1 + i%2); err != nil {
+				t.Error(err)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	if !n.runUntil(60*time.Second, func() bool { return r.count() == 120 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	checkInOrder(t, r, 120)
+}
+
+/* the packets are delivered on arrival, the gaps are still recovered */
+func TestUnorderedDelivery(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Loss: 0.2, Delay: 5 * time.Millisecond})
+
+	p := n.profile()
+	p.SendWindowSize = 256
+	a := n.join("10.0.0.1", p)
+
+	r := &recorder{}
+	p = n.profile()
+	p.Handler = r
+	p.RcvWindowSize = 256
+	p.Ordered = false
+	n.join("10.0.0.2", p)
+
+	sendPackets(t, a, 0, 200)
+
+	if !n.runUntil(time.Minute, func() bool { return r.count() >= 200 }) {
+		t.Fatalf("received %d packets", r.count())
+	}
+	n.run(time.Second)
+
+	data := r.received()
+	if len(data) != 200 {
+		t.Fatalf("received %d packets", len(data))
+	}
+
+	seen := make(map[string]bool)
+	ordered := true
+
+	for i, d := range data {
+		if seen[d] {
+			t.Fatalf("%s delivered twice", d)
+		}
+		seen[d] = true
+
+		if d != strconv.Itoa(i) {
+			ordered = false
+		}
+	}
+	if ordered {
+		t.Fatal("delivered in order despite the losses")
+	}
+}
+
+/*
+ * the receiver reports sampled by the sender bring the mean round trip time
+ * from its initial value to the round trip time of the link.
+ */
+func TestMRTTConvergesToRoundTripTime(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 40 * time.Millisecond})
+
+	/* the receivers are asked for reports every second */
+
+	p := n.profile()
+	p.RcvReportSelInterval = 1000
+	a := n.join("10.0.0.1", p)
+	n.join("10.0.0.2", n.profile())
+
+	initial := a.DomainStats(1).MRTT
+
+	/* the sender reports, which carry the selection, stop when idle */
+
+	for i := 0; i < 20; i++ {
+		sendPackets(t, a, i, 1)
+		n.run(3 * time.Second)
+	}
+
+	history := a.MRTTHistory(1)
+	if len(history) < 5 {
+		t.Fatalf("%d mrtt updates", len(history))
+	}
+
+	/* each sample moves the mrtt by 1/8 towards it */
+
+	prev := int(initial / time.Millisecond)
+	for _, h := range history {
+		if h.RTT < 75 || h.RTT > 90 {
+			t.Fatalf("rtt sample %d ms over a link with a round trip time of 80 ms", h.RTT)
+		}
+		if step := h.MRTT - prev; step < (h.RTT-prev)/8-1 || step > (h.RTT-prev)/8+1 {
+			t.Fatalf("mrtt updated from %d to %d ms by a sample of %d ms", prev, h.MRTT, h.RTT)
+		}
+		prev = h.MRTT
+	}
+
+	mrtt := a.DomainStats(1).MRTT
+	if mrtt < 40*time.Millisecond || mrtt > 90*time.Millisecond {
+		t.Fatalf("mrtt %v after %d samples, initially %v", mrtt, len(history), initial)
+	}
+	if time.Duration(prev)*time.Millisecond != mrtt {
+		t.Fatalf("last update to %d ms, mrtt %v", prev, mrtt)
+	}
+}
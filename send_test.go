@@ -0,0 +1,182 @@
+package lrmp_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+/**
+ * waits in real time until cond holds, the virtual clock is not advanced.
+ */
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+/**
+ * returns a profile with a low rate, the sender waits after each packet
+ * until the virtual clock is advanced.
+ */
+func slowProfile(n *testNet) *lrmp.Profile {
+	p := n.profile()
+	p.MinRate = 8
+	p.MaxRate = 8
+	return p
+}
+
+func TestQueueLenAndQueueFull(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", slowProfile(n))
+
+	/* the first packet is sent, the others wait for the clock */
+
+	for i := 0; i < a.QueueCap()+1; i++ {
+		if err := a.TrySend(newPacket("x")); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+		if i == 0 {
+			waitFor(t, func() bool { return a.QueueLen() == 0 })
+		}
+	}
+
+	if n := a.QueueLen(); n != a.QueueCap() {
+		t.Fatalf("queue length %d, want %d", n, a.QueueCap())
+	}
+	if err := a.TrySend(newPacket("x")); err != lrmp.ErrQueueFull {
+		t.Fatalf("TrySend on a full queue returned %v", err)
+	}
+
+	/* a Send waiting for room keeps TrySend busy */
+
+	sent := make(chan error, 1)
+	go func() { sent <- a.Send(newPacket("x")) }()
+
+	waitFor(t, func() bool { return errors.Is(a.TrySend(newPacket("x")), lrmp.ErrBusy) })
+
+	a.Stop()
+
+	if err := <-sent; err != lrmp.ErrClosed {
+		t.Fatalf("waiting Send returned %v after Stop", err)
+	}
+}
+
+func TestSendRejectedOnceClosing(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", slowProfile(n))
+
+	sendPackets(t, a, 0, 1)
+	waitFor(t, func() bool { return a.QueueLen() == 0 })
+	sendPackets(t, a, 1, 10)
+
+	closed := closeAsync(context.Background(), a)
+
+	/* the packets accepted before Close starts are sent as well */
+
+	queued := 10
+
+	waitFor(t, func() bool {
+		err := a.TrySend(newPacket("x"))
+		if err == nil {
+			queued++
+		}
+		return err == lrmp.ErrClosed
+	})
+
+	/* the queue has room, but no packet is accepted anymore */
+
+	for i := 0; i < 100; i++ {
+		if err := a.Send(newPacket("x")); err != lrmp.ErrClosed {
+			t.Fatalf("Send while closing returned %v", err)
+		}
+		if err := a.TrySend(newPacket("x")); err != lrmp.ErrClosed {
+			t.Fatalf("TrySend while closing returned %v", err)
+		}
+	}
+
+	/* the drain mark is not counted */
+
+	if n := a.QueueLen(); n != queued {
+		t.Fatalf("queue length %d while closing, want %d", n, queued)
+	}
+
+	if !n.runUntil(time.Minute, func() bool { done, _ := closed(); return done }) {
+		t.Fatal("Close has not returned")
+	}
+	if n := a.QueueLen(); n != 0 {
+		t.Fatalf("queue length %d after Close", n)
+	}
+}
+
+func TestSendContextCancelled(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", slowProfile(n))
+
+	sendPackets(t, a, 0, 1)
+	waitFor(t, func() bool { return a.QueueLen() == 0 })
+
+	for a.QueueLen() < a.QueueCap() {
+		if err := a.SendContext(context.Background(), newPacket("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	/* the queue stays full as the clock is not advanced */
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := a.SendContext(ctx, newPacket("x")); err != context.DeadlineExceeded {
+		t.Fatalf("SendContext on a full queue returned %v", err)
+	}
+
+	/* a send waiting behind another one gives up too */
+
+	sent := make(chan error, 1)
+	go func() { sent <- a.Send(newPacket("x")) }()
+
+	waitFor(t, func() bool { return errors.Is(a.TrySend(newPacket("x")), lrmp.ErrBusy) })
+
+	ctx, cancel = context.WithCancel(context.Background())
+	waiting := make(chan error, 1)
+	go func() { waiting <- a.SendContext(ctx, newPacket("x")) }()
+	cancel()
+
+	if err := <-waiting; err != context.Canceled {
+		t.Fatalf("waiting SendContext returned %v", err)
+	}
+	if n := a.QueueLen(); n != a.QueueCap() {
+		t.Fatalf("queue length %d, want %d", n, a.QueueCap())
+	}
+
+	/* the first waiting send gets the room made by the clock */
+
+	var err error
+
+	if !n.runUntil(time.Minute, func() bool {
+		select {
+		case err = <-sent:
+			return true
+		default:
+			return false
+		}
+	}) {
+		t.Fatal("waiting Send has not returned")
+	}
+	if err != nil {
+		t.Fatalf("waiting Send returned %v", err)
+	}
+}
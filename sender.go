@@ -17,7 +17,6 @@ type sender struct {
 	lastseq         int64
 	rrAbsLost       int
 	rrMaxSeqno      int64
-	lastError       int64
 	packets         int
 	bytes           int
 	rate            int
@@ -38,6 +37,24 @@ type sender struct {
 	rrSelectTime    time.Time
 	rrReplies       int
 	lost            bool
+	fecBlocks       []*fecBlock
+	fecSeen         bool
+	fecBlockSize    int
+	fecNext         int64
+	syncErrors      int
+	gaps            int
+	evictLost       int
+	evictMaxSeqno   int64
+	evictRate       int
+	highLoss        int
+	/* the sender has left, finalSeqno is its last packet */
+	bye        bool
+	finalSeqno int64
+	/* the loss being reported, extended while the losses are continuous */
+	pendingLoss *SequenceError
+	/* the smaller cache size applied once expected reaches shrinkSeqno */
+	shrinkSize  int
+	shrinkSeqno int64
 }
 
 func newSender(id uint32, ip net.IP, start int64) *sender {
@@ -54,7 +71,6 @@ func (s *sender) resetWithSeqNo(initialSeqno int64) {
 
 	s.reset()
 
-	s.lastError = 0
 	s.packets = 0
 	s.bytes = 0
 	s.rate = 0
@@ -71,6 +87,10 @@ func (s *sender) resetWithSeqNo(initialSeqno int64) {
 	s.duplicates = 0
 	s.repairs = 0
 	s.drops = 0
+	s.fecBlocks = nil
+	s.syncErrors = 0
+	s.highLoss = 0
+	s.bye = false
 
 	s.clearCache(initialSeqno)
 }
@@ -78,6 +98,41 @@ func (s *sender) resetWithSeqNo(initialSeqno int64) {
 func (s *sender) initCache(cacheSize int) {
 	s.cache = newPacketCache(cacheSize)
 	s.cacheSize = s.cache.getMaxSize()
+	s.shrinkSize = 0
+}
+
+/**
+ * changes the size of the cache without losing the packets cached. A larger
+ * cache is used at once. A smaller one only after as many more packets as
+ * the size difference, the oldest packets of the current cache being still
+ * needed for repairs until then.
+ */
+func (s *sender) resizeCache(cacheSize int) {
+	size := roundCacheSize(cacheSize)
+
+	s.shrinkSize = 0
+
+	if size > s.cacheSize {
+		s.cache = s.cache.resize(size)
+		s.cacheSize = size
+	} else if size < s.cacheSize {
+		s.shrinkSize = size
+		s.shrinkSeqno = s.expected + int64(s.cacheSize-size)
+	}
+}
+
+/**
+ * applies a pending shrink once expected has reached shrinkSeqno and the
+ * packets not yet delivered fit in the smaller cache.
+ */
+func (s *sender) checkShrink() {
+	if s.shrinkSize == 0 || diff32(s.expected, s.shrinkSeqno) < 0 || diff32(s.maxseq, s.expected) >= s.shrinkSize {
+		return
+	}
+
+	s.cache = s.cache.resize(s.shrinkSize)
+	s.cacheSize = s.shrinkSize
+	s.shrinkSize = 0
 }
 
 func (s *sender) clearCache(initialSeqno int64) {
@@ -87,8 +142,34 @@ func (s *sender) clearCache(initialSeqno int64) {
 	s.lastseq = s.maxseq
 	s.rrAbsLost = 0
 	s.rrMaxSeqno = s.maxseq
+	s.gaps = 0
+	s.evictLost = 0
+	s.evictMaxSeqno = s.maxseq
+
+	/* nothing cached, a pending shrink is applied at once */
+
+	if s.shrinkSize > 0 {
+		s.initCache(s.shrinkSize)
+	} else {
+		s.cache.clear()
+	}
+}
+
+/**
+ * returns the number of packets up to maxseq not yet delivered. Those past
+ * a gap are delivered already when the delivery is not ordered. As the
+ * handler is called before the next packet is read, this is the backlog
+ * waiting for a repair, not the data held by a slow application.
+ */
+func (s *sender) undelivered() int {
+	n := 0
 
-	s.cache.clear()
+	for seqno := s.expected; diff32(s.maxseq, seqno) >= 0; seqno++ {
+		if p := s.getPacket(seqno); p == nil || !p.delivered {
+			n++
+		}
+	}
+	return n
 }
 func (s *sender) setRate(rate int) {
 	s.rate = rate
@@ -102,8 +183,34 @@ func (s *sender) incDuplicate() {
 func (s *sender) getPacket(seqno int64) *Packet {
 	return s.cache.getPacket(seqno)
 }
+
+/**
+ * updates the interarrival jitter from the NTP timestamp of a data packet
+ * received at lastTimeForData, as done by RTP. jitter is in 1/16 millis.
+ */
 func (s *sender) updateJitter(timestamp int) {
+	elapsed := fixedPoint32ToMillis(ntp32(unixMillis(s.lastTimeForData)) - timestamp)
+
+	d := 0
+
+	if s.transit != 0 {
+		d = elapsed - s.transit
+	}
+
+	s.transit = elapsed
+
+	if d < 0 {
+		d = -d
+	}
+
+	s.jitter += d - ((s.jitter + 8) >> 4)
+}
 
+/**
+ * returns the interarrival jitter in millis.
+ */
+func (s *sender) getJitter() int {
+	return s.jitter >> 4
 }
 func (s *sender) incPackets() {
 	s.packets++
@@ -116,6 +223,7 @@ func (s *sender) putPacket(packet *Packet) {
 }
 func (s *sender) incExpected() {
 	s.expected++
+	s.checkShrink()
 }
 func (s *sender) removePacket(packet *Packet) {
 	s.cache.removePacket(packet)
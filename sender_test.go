@@ -0,0 +1,97 @@
+package lrmp
+
+import (
+	"net"
+	"testing"
+)
+
+/**
+ * sends count packets as the flow does.
+ */
+func sendCached(s *sender, count int) {
+	for i := 0; i < count; i++ {
+		p := NewPacket(true, 0)
+		p.seqno = s.expected
+		s.incExpected()
+		s.appendPacket(p)
+	}
+}
+
+func TestCacheShrinksOnceOldPacketsExpire(t *testing.T) {
+	s := newSender(1, net.ParseIP("10.0.0.1"), 0)
+	s.initCache(64)
+
+	sendCached(s, 64)
+
+	/* the 64 packets are still needed for repairs */
+
+	s.resizeCache(16)
+
+	if s.cacheSize != 64 {
+		t.Fatalf("cache size %d, want 64 until the old packets expire", s.cacheSize)
+	}
+
+	sendCached(s, 47)
+
+	for seqno := int64(111 - 64); seqno < 111; seqno++ {
+		if !s.isCached(seqno) {
+			t.Fatalf("#%d dropped before the shrink", seqno)
+		}
+	}
+
+	sendCached(s, 1)
+
+	if s.cacheSize != 16 {
+		t.Fatalf("cache size %d, want 16", s.cacheSize)
+	}
+	for seqno := int64(112 - 16); seqno < 112; seqno++ {
+		if !s.isCached(seqno) {
+			t.Fatalf("#%d dropped by the shrink", seqno)
+		}
+	}
+
+	/* a larger cache is used at once */
+
+	s.resizeCache(100)
+
+	if s.cacheSize != 128 || !s.isCached(111) {
+		t.Fatalf("cache size %d after growing", s.cacheSize)
+	}
+}
+
+func TestReceiverCacheShrinksOnceOutstandingPacketsFit(t *testing.T) {
+	s := newSender(1, net.ParseIP("10.0.0.1"), 0)
+	s.initCache(64)
+
+	s.resizeCache(16)
+
+	/* #40 delayed, up to #100 received */
+
+	for seqno := int64(0); seqno <= 100; seqno++ {
+		if seqno != 40 {
+			p := NewPacket(true, 0)
+			p.seqno = seqno
+			s.putPacket(p)
+			s.maxseq = seqno
+		}
+		if seqno < 40 {
+			s.incExpected()
+		}
+	}
+
+	/* #40 to #100 do not fit in 16 packets */
+
+	for s.expected < 84 {
+		s.incExpected()
+
+		if s.cacheSize != 64 {
+			t.Fatalf("cache shrunk at #%d", s.expected)
+		}
+	}
+
+	s.incExpected()
+
+	if s.cacheSize != 16 || !s.isCached(100) || !s.isCached(85) {
+		t.Fatalf("cache size %d, want 16", s.cacheSize)
+	}
+}
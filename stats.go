@@ -2,36 +2,46 @@ package lrmp
 
 import "time"
 
+/**
+ * Stats holds the counters of the session, a copy is returned by Lrmp.Stats.
+ */
 type Stats struct {
-	badLength              int
-	badVersion             int
-	ctrlPackets            int
-	ctrlBytes              int64
-	dataPackets            int
-	dataBytes              int64
-	senderReports          int
-	rrSelect               int
-	receiverReports        int
-	populationEstimate     int
-	populationEstimateTime time.Time
-	failures               int
-	outOfBand              int
-}
-type DomainStats struct {
-	childScope           int
-	enabled              bool
-	mrtt                 int // in 1/8 miilisecs
-	repairPackets        int
-	repairBytes          int64
-	nack                 int
-	dupNack              int
-	nackReply            int
-	thirdPartyRepairs    int
-	dupPackets           int
-	dupBytes             int64
-	thirdPartyDuplicates int
+	BadLength              int
+	BadVersion             int
+	CtrlPackets            int
+	CtrlBytes              int64
+	DataPackets            int
+	DataBytes              int64
+	SenderReports          int
+	RrSelect               int
+	ReceiverReports        int
+	PopulationEstimate     int
+	PopulationEstimateTime time.Time
+	Failures               int
+	OutOfBand              int
+	FecPackets             int
+	FecRecovered           int
+	CongestionIndications  int
 }
 
-func (stats *DomainStats) getRTT() int {
-	return stats.mrtt >> 3
+/**
+ * DomainStats holds the state and counters of a recovery domain, a copy is
+ * returned by Lrmp.DomainStats and Lrmp.Domains. ParentScope is -1 for the
+ * top level domain and ChildScope is 0 for the lowest one.
+ */
+type DomainStats struct {
+	Scope                int
+	ParentScope          int
+	ChildScope           int
+	Enabled              bool
+	MRTT                 time.Duration // mean round trip time
+	RepairPackets        int
+	RepairBytes          int64
+	Nack                 int
+	DupNack              int
+	NackReply            int
+	ThirdPartyRepairs    int
+	DupPackets           int
+	DupBytes             int64
+	ThirdPartyDuplicates int
 }
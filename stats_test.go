@@ -0,0 +1,80 @@
+package lrmp_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp/vnet"
+)
+
+func TestDomainStats(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.joinTTL("10.0.0.1", 63, n.profile())
+
+	/* from the top level domain down to the lowest one */
+
+	var scopes []int
+	for _, d := range a.Domains() {
+		scopes = append(scopes, d.Scope)
+	}
+	if fmt.Sprint(scopes) != "[63 47 15]" {
+		t.Fatalf("domains %v", scopes)
+	}
+
+	domains := a.Domains()
+	for i, d := range domains {
+		parent, child := -1, 0
+		if i > 0 {
+			parent = domains[i-1].Scope
+		}
+		if i < len(domains)-1 {
+			child = domains[i+1].Scope
+		}
+		if d.ParentScope != parent || d.ChildScope != child || !d.Enabled || d.MRTT <= 0 {
+			t.Fatalf("domain %+v", d)
+		}
+	}
+
+	/* the smallest domain which covers the scope */
+
+	for scope, want := range map[int]int{1: 15, 15: 15, 20: 47, 63: 63, 255: 63} {
+		if d := a.DomainStats(scope); d.Scope != want {
+			t.Fatalf("scope %d in domain %d, want %d", scope, d.Scope, want)
+		}
+	}
+}
+
+func TestStats(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{})
+
+	a := n.join("10.0.0.1", n.profile())
+	b := n.join("10.0.0.2", n.profile())
+
+	sendPackets(t, a, 0, 30)
+
+	if !n.runUntil(time.Minute, func() bool { return b.Stats().DataPackets == 30 }) {
+		t.Fatalf("received %d packets", b.Stats().DataPackets)
+	}
+
+	/* the data packets sent and received are counted on both sides */
+
+	sent, received := a.Stats(), b.Stats()
+
+	if sent.DataPackets != 30 || sent.DataBytes != received.DataBytes {
+		t.Fatalf("sent %d packets, %d bytes, received %d bytes", sent.DataPackets, sent.DataBytes, received.DataBytes)
+	}
+	if sent.SenderReports == 0 || received.SenderReports == 0 || sent.CtrlPackets == 0 {
+		t.Fatalf("sender %+v, receiver %+v", sent, received)
+	}
+
+	/* the snapshot is not changed by the session */
+
+	sendPackets(t, a, 30, 10)
+	n.runUntil(time.Minute, func() bool { return b.Stats().DataPackets == 40 })
+
+	if received.DataPackets != 30 {
+		t.Fatalf("snapshot changed to %d packets", received.DataPackets)
+	}
+}
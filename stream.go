@@ -0,0 +1,311 @@
+package lrmp
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+/*
+ * Byte stream view of a session. A Writer packs the bytes written into
+ * reliable packets, a StreamReceiver set as the profile handler yields one
+ * Reader per source with the bytes delivered in order.
+ */
+
+const streamPacketLen = fecMaxData
+
+/* the data of a stream buffered until read, in bytes */
+const streamBufferLen = 64 * streamPacketLen
+
+/**
+ * the error returned by a Reader once data of the stream is lost.
+ */
+var ErrStreamLost = errors.New("data lost in stream")
+
+/**
+ * Writer sends the bytes written as reliable packets of the session. Data
+ * is buffered until a packet is full, Flush sends a partial packet. A
+ * Writer must not be used concurrently.
+ */
+type Writer struct {
+	l *Lrmp
+	p *Packet
+}
+
+func NewWriter(l *Lrmp) *Writer {
+	w := Writer{l: l}
+	return &w
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	written := 0
+
+	for len(b) > 0 {
+		if w.p == nil {
+			w.p = NewPacket(true, streamPacketLen)
+		}
+
+		n := copy(w.p.GetDataBuffer()[w.p.datalen:streamPacketLen], b)
+
+		w.p.datalen += n
+		written += n
+		b = b[n:]
+
+		if w.p.datalen == streamPacketLen {
+			err := w.Flush()
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+/**
+ * sends the buffered data.
+ */
+func (w *Writer) Flush() error {
+	if w.p == nil || w.p.datalen == 0 {
+		return nil
+	}
+
+	p := w.p
+	w.p = nil
+
+	return w.l.Send(p)
+}
+
+func (w *Writer) Close() error {
+	return w.Flush()
+}
+
+/**
+ * Reader yields the data stream of one source. Read returns io.EOF when the
+ * source is gone, and ErrStreamLost after the buffered data if a part of the
+ * stream could not be received.
+ */
+type Reader struct {
+	sync.Mutex
+	cond     *sync.Cond
+	source   Identity
+	chunks   [][]byte
+	buffered int
+	err      error
+}
+
+func newReader(source Identity) *Reader {
+	r := Reader{source: source}
+	r.cond = sync.NewCond(&r)
+	return &r
+}
+
+/**
+ * returns the source of the stream.
+ */
+func (r *Reader) Source() Identity {
+	return r.source
+}
+
+func (r *Reader) Read(b []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	for len(r.chunks) == 0 && r.err == nil {
+		r.cond.Wait()
+	}
+
+	if len(r.chunks) == 0 {
+		return 0, r.err
+	}
+
+	n := copy(b, r.chunks[0])
+
+	if n < len(r.chunks[0]) {
+		r.chunks[0] = r.chunks[0][n:]
+	} else {
+		r.chunks[0] = nil
+		r.chunks = r.chunks[1:]
+	}
+
+	r.buffered -= n
+	r.cond.Broadcast()
+
+	return n, nil
+}
+
+/**
+ * stops reading the stream, the data buffered and still to come is
+ * discarded so that the session is not held up. Read returns io.EOF.
+ */
+func (r *Reader) Close() error {
+	r.drop(io.EOF)
+	return nil
+}
+
+/**
+ * buffers the data, waiting for the reader while the buffer is full.
+ */
+func (r *Reader) put(data []byte) {
+	r.Lock()
+	defer r.Unlock()
+
+	for r.buffered >= streamBufferLen && r.err == nil {
+		r.cond.Wait()
+	}
+
+	if r.err == nil {
+		r.chunks = append(r.chunks, data)
+		r.buffered += len(data)
+		r.cond.Broadcast()
+	}
+}
+
+func (r *Reader) fail(err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.err == nil {
+		r.err = err
+		r.cond.Broadcast()
+	}
+}
+
+/**
+ * fails the stream at once, discarding the data buffered.
+ */
+func (r *Reader) drop(err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.chunks = nil
+	r.buffered = 0
+
+	if r.err == nil {
+		r.err = err
+	}
+	r.cond.Broadcast()
+}
+
+/**
+ * StreamReceiver is the EventHandler to set in the profile to read the data
+ * of each source as a stream. The data is buffered until read, so that the
+ * streams can be consumed in any order. Once streamBufferLen bytes of a
+ * stream are buffered the delivery waits for its reader, holding up the
+ * session as OverflowBlock does, so each stream accepted must be read or
+ * closed. The profile must be ordered.
+ */
+type StreamReceiver struct {
+	sync.Mutex
+	cond    *sync.Cond
+	readers map[uint32]*Reader
+	pending []*Reader
+	closed  bool
+}
+
+func NewStreamReceiver() *StreamReceiver {
+	sr := StreamReceiver{readers: make(map[uint32]*Reader)}
+	sr.cond = sync.NewCond(&sr)
+	return &sr
+}
+
+/**
+ * waits for the stream of a new source. Returns io.EOF once the receiver is
+ * closed.
+ */
+func (sr *StreamReceiver) Accept() (*Reader, error) {
+	sr.Lock()
+	defer sr.Unlock()
+
+	for len(sr.pending) == 0 && !sr.closed {
+		sr.cond.Wait()
+	}
+
+	if len(sr.pending) == 0 {
+		return nil, io.EOF
+	}
+
+	r := sr.pending[0]
+	sr.pending = sr.pending[1:]
+
+	return r, nil
+}
+
+/**
+ * unblocks Accept, the streams already accepted remain readable. The
+ * streams not yet accepted and those of new sources are discarded.
+ */
+func (sr *StreamReceiver) Close() error {
+	sr.Lock()
+	defer sr.Unlock()
+
+	sr.closed = true
+	sr.cond.Broadcast()
+
+	for _, r := range sr.pending {
+		delete(sr.readers, r.source.ID)
+		r.drop(io.EOF)
+	}
+	sr.pending = nil
+
+	return nil
+}
+
+func (sr *StreamReceiver) needsOrder() {}
+
+func (sr *StreamReceiver) ProcessData(p *Packet) {
+	if !p.reliable {
+		return
+	}
+
+	sr.Lock()
+
+	id := p.source.getID()
+
+	r := sr.readers[id]
+
+	if r == nil {
+		if sr.closed {
+			sr.Unlock()
+			return
+		}
+
+		r = newReader(identityOf(p.source))
+		sr.readers[id] = r
+		sr.pending = append(sr.pending, r)
+		sr.cond.Signal()
+	}
+
+	sr.Unlock()
+
+	r.put(append([]byte(nil), p.GetDataBuffer()[:p.datalen]...))
+}
+
+func (sr *StreamReceiver) ProcessEvent(event int, data interface{}) {
+	switch ev := data.(type) {
+	case *SequenceError:
+
+		/* the rest of the stream is discarded until the source is gone */
+
+		sr.Lock()
+		r := sr.readers[ev.Source.ID]
+		sr.Unlock()
+
+		if r != nil {
+			r.fail(ErrStreamLost)
+		}
+	case *EndOfSequence:
+		if r := sr.remove(ev.Source.ID); r != nil {
+			r.fail(io.EOF)
+		}
+	}
+}
+
+func (sr *StreamReceiver) remove(id uint32) *Reader {
+	sr.Lock()
+	defer sr.Unlock()
+
+	r := sr.readers[id]
+	delete(sr.readers, id)
+
+	return r
+}
@@ -0,0 +1,226 @@
+package lrmp_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+	"github.com/robaho/lrmp/vnet"
+)
+
+func TestStreamOverLossyLink(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Loss: 0.1, Delay: 5 * time.Millisecond})
+
+	p := n.profile()
+	p.SendWindowSize = 256
+	a := n.join("10.0.0.1", p)
+
+	sr := lrmp.NewStreamReceiver()
+	p = n.profile()
+	p.Handler = sr
+	p.RcvWindowSize = 256
+	n.join("10.0.0.2", p)
+
+	data := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	/* the reader blocks in real time, the clock is advanced meanwhile */
+
+	var mu sync.Mutex
+	var got []byte
+
+	go func() {
+		r, err := sr.Accept()
+		if err != nil {
+			return
+		}
+		buff := make([]byte, 777)
+		for {
+			n, err := r.Read(buff)
+			mu.Lock()
+			got = append(got, buff[:n]...)
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	w := lrmp.NewWriter(a)
+
+	if _, err := io.Copy(w, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got)
+	}
+
+	if !n.runUntil(time.Minute, func() bool { return received() == len(data) }) {
+		t.Fatalf("read %d of %d bytes", received(), len(data))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("stream corrupted")
+	}
+
+	sr.Close()
+}
+
+/* joins a fast sender and a stream receiver */
+func streamSession(n *testNet, sr *lrmp.StreamReceiver) (*lrmp.Lrmp, *lrmp.Lrmp) {
+	p := n.profile()
+	p.MinRate = 1000
+	p.MaxRate = 2000
+	a := n.join("10.0.0.1", p)
+
+	p = n.profile()
+	p.Handler = sr
+	b := n.join("10.0.0.2", p)
+
+	return a, b
+}
+
+/* the packets of the sender received so far */
+func receivedFrom(l *lrmp.Lrmp) int {
+	if senders := l.Members().Senders; len(senders) == 1 {
+		return senders[0].Packets
+	}
+	return 0
+}
+
+/* a stream not read holds up the delivery until it is read */
+func TestStreamBufferBounded(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	sr := lrmp.NewStreamReceiver()
+	a, b := streamSession(n, sr)
+
+	data := make([]byte, 200*1400)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	w := lrmp.NewWriter(a)
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	n.run(10 * time.Second)
+
+	if received := receivedFrom(b); received == 0 || received > 100 {
+		t.Fatalf("%d packets received without reading", received)
+	}
+
+	r, err := sr.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var got []byte
+
+	go func() {
+		buff := make([]byte, 1000)
+		for {
+			n, err := r.Read(buff)
+			mu.Lock()
+			got = append(got, buff[:n]...)
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	received := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(got)
+	}
+
+	if !n.runUntil(time.Minute, func() bool { return received() == len(data) }) {
+		t.Fatalf("read %d of %d bytes", received(), len(data))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !bytes.Equal(got, data) {
+		t.Fatal("stream corrupted")
+	}
+}
+
+/* once closed, the streams not accepted are discarded instead of held up */
+func TestStreamReceiverCloseDropsPending(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	sr := lrmp.NewStreamReceiver()
+	a, b := streamSession(n, sr)
+
+	w := lrmp.NewWriter(a)
+	if _, err := w.Write(make([]byte, 100*1400)); err != nil {
+		t.Fatal(err)
+	}
+
+	n.run(5 * time.Second)
+	sr.Close()
+
+	if _, err := sr.Accept(); err != io.EOF {
+		t.Fatalf("accept after close: %v", err)
+	}
+
+	if _, err := w.Write(make([]byte, 100*1400)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	/* the data is no longer buffered, the session is not held up */
+
+	if !n.runUntil(time.Minute, func() bool { return a.QueueLen() == 0 && receivedFrom(b) >= 200 }) {
+		t.Fatalf("%d packets received after close, %d queued", receivedFrom(b), a.QueueLen())
+	}
+}
+
+/* a stream closed by the application is discarded */
+func TestStreamReaderClose(t *testing.T) {
+	n := newTestNet(t, vnet.LinkConfig{Delay: 5 * time.Millisecond})
+	sr := lrmp.NewStreamReceiver()
+	a, b := streamSession(n, sr)
+
+	w := lrmp.NewWriter(a)
+	if _, err := w.Write(make([]byte, 200*1400)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		r, err := sr.Accept()
+		if err != nil {
+			return
+		}
+		r.Close()
+		if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Errorf("read %d bytes from a closed stream: %v", n, err)
+		}
+	}()
+
+	if !n.runUntil(time.Minute, func() bool { return a.QueueLen() == 0 && receivedFrom(b) >= 200 }) {
+		t.Fatalf("%d packets received, %d queued", receivedFrom(b), a.QueueLen())
+	}
+}
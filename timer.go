@@ -1,7 +1,6 @@
 package lrmp
 
 import (
-	"container/list"
 	"sync"
 	"time"
 )
@@ -10,16 +9,22 @@ type timerTask struct {
 	time    time.Time
 	data    interface{}
 	handler timerHandler
+	timer   Timer
 }
 
 type timerHandler interface {
 	handleTimerTask(data interface{}, time time.Time)
 }
 
+/**
+ * each task is scheduled with Clock.AfterFunc, its handler is called from
+ * the clock, i.e. by VirtualClock.Advance for a virtual clock.
+ */
 type timerManager struct {
 	sync.Mutex
-	tasks  list.List
-	wakeup chan bool
+	tasks   map[*timerTask]struct{}
+	stopped bool
+	clock   Clock
 }
 
 /**
@@ -35,84 +40,56 @@ const UNRECOVERABLE_SEQUENCE_ERROR = 1
  */
 const END_OF_SEQUENCE = 2
 
-func newTimerManager() *timerManager {
-	em := &timerManager{wakeup: make(chan bool, 16)}
-
-	go func() {
-		for {
-			em.Lock()
-
-			timeout := time.Duration(10 * time.Second)
-
-			if em.tasks.Len() > 0 {
-				first := em.tasks.Front().Value.(*timerTask)
-				timeout = first.time.Sub(time.Now())
-			}
-
-			if timeout < 0 {
-				timeout = 1
-			}
-
-			em.Unlock()
+func newTimerManager(clock Clock) *timerManager {
+	return &timerManager{tasks: make(map[*timerTask]struct{}), clock: clock}
+}
 
-			select {
-			case <-em.wakeup:
-			case <-time.After(timeout):
-			}
-			em.Lock()
+/**
+ * calls the handler of a task which is due, unless it has been recalled.
+ */
+func (em *timerManager) fire(task *timerTask) {
+	em.Lock()
+	_, pending := em.tasks[task]
+	delete(em.tasks, task)
+	em.Unlock() // need to unlock because task handler might try to submit another task...
 
-			var task *timerTask
-			if em.tasks.Len() > 0 {
-				task = em.tasks.Front().Value.(*timerTask)
-				if task.time.Before(time.Now()) {
-					em.tasks.Remove(em.tasks.Front())
-				} else {
-					task = nil
-				}
-			}
+	if pending {
+		task.handler.handleTimerTask(task.data, task.time)
+	}
+}
 
-			em.Unlock() // need to unlock because task handler might try to submit another task...
+/**
+ * stops the timers, the pending tasks are dropped.
+ */
+func (em *timerManager) stop() {
+	em.Lock()
+	defer em.Unlock()
 
-			if task != nil {
-				task.handler.handleTimerTask(task.data, task.time)
-			}
-		}
-	}()
+	em.stopped = true
 
-	return em
+	for task := range em.tasks {
+		task.timer.Stop()
+		delete(em.tasks, task)
+	}
 }
 
 func (em *timerManager) recallTimer(task *timerTask) {
 	em.Lock()
 	defer em.Unlock()
-	for next := em.tasks.Front(); next != nil; next = next.Next() {
-		if next.Value == task {
-			em.tasks.Remove(next)
-			return
-		}
+	if _, pending := em.tasks[task]; pending {
+		delete(em.tasks, task)
+		task.timer.Stop()
 	}
 }
 func (em *timerManager) registerTimer(ms int, handler timerHandler, data interface{}) *timerTask {
-	t := timerTask{time: addMillis(time.Now(), ms), handler: handler, data: data}
+	t := timerTask{time: addMillis(em.clock.Now(), ms), handler: handler, data: data}
 	em.Lock()
+	defer em.Unlock()
 
-	// insert in ascending order
-	for elem := em.tasks.Front(); ; elem = elem.Next() {
-		if elem == nil {
-			em.tasks.PushFront(&t)
-			break
-		} else if t.time.Before(elem.Value.(*timerTask).time) {
-			em.tasks.InsertBefore(&t, elem)
-			break
-		} else if elem == em.tasks.Back() {
-			em.tasks.PushBack(&t)
-			break
-		}
+	if !em.stopped {
+		em.tasks[&t] = struct{}{}
+		t.timer = em.clock.AfterFunc(time.Duration(ms)*time.Millisecond, func() { em.fire(&t) })
 	}
 
-	em.Unlock()
-
-	em.wakeup <- true
-
 	return &t
 }
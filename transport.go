@@ -0,0 +1,465 @@
+package lrmp
+
+import (
+	"errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+	"strconv"
+	"sync"
+)
+
+var errNotSourceSpecific = errors.New("not a source specific group")
+
+/**
+ * Transport carries LRMP packets to and from the session group. The default
+ * implementation is MulticastTransport, alternative carriers can be plugged
+ * in using NewLrmpWithTransport.
+ */
+type Transport interface {
+	/**
+	 * reads the next packet into b, returning the number of bytes read and
+	 * the address of the originating host.
+	 */
+	ReadFrom(b []byte) (n int, src net.IP, err error)
+	/**
+	 * sends b to the group using the given TTL.
+	 */
+	WriteTo(b []byte, ttl int) (n int, err error)
+	/**
+	 * returns the address under which the local entity is known by the group.
+	 */
+	LocalAddr() net.IP
+	Close() error
+}
+
+/**
+ * ScopedTransport is implemented by transports whose group address limits
+ * how far packets travel regardless of the TTL, e.g. IPv6 multicast scopes.
+ * The session TTL must not exceed MaxScope.
+ */
+type ScopedTransport interface {
+	Transport
+	MaxScope() int
+}
+
+/**
+ * MembershipTransport is implemented by transports which can stop and resume
+ * the reception of the group without closing, as used by receivers which
+ * leave the session on high loss.
+ */
+type MembershipTransport interface {
+	Transport
+	LeaveGroup() error
+	JoinGroup() error
+}
+
+/**
+ * MulticastTransport is a Transport over an IPv4 or IPv6 multicast UDP
+ * socket. For IPv6 the TTL is used as the hop limit.
+ *
+ * The group is either joined for any source, or source specific (SSM) for
+ * a list of permitted sources using IGMPv3/MLDv2 source filters.
+ */
+type MulticastTransport struct {
+	sync.Mutex
+	conn4   *ipv4.PacketConn
+	conn6   *ipv6.PacketConn
+	group   *net.UDPAddr
+	laddr   net.IP
+	ifi     *net.Interface
+	ssm     bool
+	sources []net.IP
+}
+
+/**
+ * joins the multicast group addr:port on the named network interface. The
+ * address family of the group selects IPv4 or IPv6.
+ */
+func NewMulticastTransport(addr string, port int, network string) (*MulticastTransport, error) {
+	return newMulticastTransport(addr, port, network, nil)
+}
+
+/**
+ * joins the multicast group addr:port on the named network interface as
+ * (S,G) for each of the given sources. Only packets from these sources are
+ * received, so receivers that take part in local recovery should also
+ * permit their peers with AllowSource.
+ */
+func NewSourceSpecificTransport(addr string, port int, network string, sources []net.IP) (*MulticastTransport, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no source for source specific group " + addr)
+	}
+	return newMulticastTransport(addr, port, network, sources)
+}
+
+func newMulticastTransport(addr string, port int, network string, sources []net.IP) (*MulticastTransport, error) {
+
+	group, err := net.ResolveUDPAddr("udp", net.JoinHostPort(addr, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !group.IP.IsMulticast() {
+		return nil, errors.New("not a multicast address " + addr)
+	}
+
+	ifi, err := net.InterfaceByName(network)
+	if err != nil {
+		return nil, err
+	}
+
+	v6 := group.IP.To4() == nil
+
+	laddr, err := interfaceAddr(ifi, group.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	t := MulticastTransport{group: group, laddr: laddr, ifi: ifi, ssm: sources != nil}
+
+	if v6 {
+		/* link and interface local groups are only meaningful with a zone */
+
+		if ipv6NeedsZone(group.IP) {
+			group.Zone = ifi.Name
+		}
+
+		err = t.listen6()
+	} else {
+		err = t.listen4()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, src := range sources {
+		err = t.AllowSource(src)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+
+	return &t, nil
+}
+
+/**
+ * binds the socket to the group, the any source join is done by
+ * ListenMulticastUDP while source specific joins are done per source.
+ */
+func (t *MulticastTransport) listen(network string) (net.PacketConn, error) {
+	if t.ssm {
+		return net.ListenPacket(network, t.group.String())
+	}
+	return net.ListenMulticastUDP(network, t.ifi, t.group)
+}
+
+/**
+ * returns the address of the interface used as the local address for the
+ * group.
+ */
+func interfaceAddr(ifi *net.Interface, group net.IP) (net.IP, error) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	return selectAddr(addrs, group)
+}
+
+/**
+ * returns the first address in the family of the group. For IPv6 a link
+ * local address is preferred for the interface and link local groups, a
+ * global one otherwise.
+ */
+func selectAddr(addrs []net.Addr, group net.IP) (net.IP, error) {
+	var laddr net.IP
+
+	v6 := group.To4() == nil
+
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if v6 {
+			if ipnet.IP.To4() != nil {
+				continue
+			}
+			if laddr == nil || laddr.IsLinkLocalUnicast() != ipv6NeedsZone(group) {
+				laddr = ipnet.IP
+			}
+		} else if ip := ipnet.IP.To4(); ip != nil {
+			laddr = ip
+			break
+		}
+	}
+
+	if laddr == nil {
+		return nil, errors.New("interface does not have IP address")
+	}
+	return laddr, nil
+}
+
+func (t *MulticastTransport) listen4() error {
+	l, err := t.listen("udp4")
+	if err != nil {
+		return err
+	}
+
+	socket := ipv4.NewPacketConn(l)
+	err = socket.SetMulticastInterface(t.ifi)
+	if err == nil {
+		err = socket.SetMulticastLoopback(true)
+	}
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	t.conn4 = socket
+	return nil
+}
+
+func (t *MulticastTransport) listen6() error {
+	l, err := t.listen("udp6")
+	if err != nil {
+		return err
+	}
+
+	socket := ipv6.NewPacketConn(l)
+	err = socket.SetMulticastInterface(t.ifi)
+	if err == nil {
+		err = socket.SetMulticastLoopback(true)
+	}
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	t.conn6 = socket
+	return nil
+}
+
+func (t *MulticastTransport) ReadFrom(b []byte) (int, net.IP, error) {
+	var n int
+	var addr net.Addr
+	var err error
+
+	if t.conn6 != nil {
+		n, _, addr, err = t.conn6.ReadFrom(b)
+	} else {
+		n, _, addr, err = t.conn4.ReadFrom(b)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, addr.(*net.UDPAddr).IP, nil
+}
+
+func (t *MulticastTransport) WriteTo(b []byte, ttl int) (int, error) {
+	if t.conn6 != nil {
+		t.conn6.SetMulticastHopLimit(ttl)
+		t.conn6.SetHopLimit(ttl)
+
+		return t.conn6.WriteTo(b, nil, t.group)
+	}
+
+	t.conn4.SetMulticastTTL(ttl)
+	t.conn4.SetTTL(ttl)
+
+	return t.conn4.WriteTo(b, nil, t.group)
+}
+
+func (t *MulticastTransport) LocalAddr() net.IP {
+	return t.laddr
+}
+
+/**
+ * returns the largest useful TTL for the group. IPv6 groups are bounded by
+ * the scope field of the address, which is mapped onto the TTL thresholds
+ * used by the recovery domains.
+ */
+func (t *MulticastTransport) MaxScope() int {
+	if t.conn6 == nil {
+		return 255
+	}
+	return ipv6MaxScope(t.group.IP)
+}
+
+/**
+ * returns the scope field of an IPv6 multicast address (RFC 7346).
+ */
+func ipv6Scope(ip net.IP) byte {
+	return ip[1] & 0x0f
+}
+
+/**
+ * interface and link local groups are ambiguous without an interface.
+ */
+func ipv6NeedsZone(ip net.IP) bool {
+	scope := ipv6Scope(ip)
+	return scope == 0x1 || scope == 0x2
+}
+
+func ipv6MaxScope(ip net.IP) int {
+	switch ipv6Scope(ip) {
+	case 0x1: /* interface local */
+		return 0
+	case 0x2: /* link local */
+		return 1
+	case 0x3, 0x4, 0x5: /* realm, admin and site local */
+		return 15
+	case 0x8: /* organization local */
+		return 63
+	default:
+		return 255
+	}
+}
+
+/**
+ * permits packets from the given source on a source specific group, this is
+ * how receivers accept the repairs and control packets of their peers.
+ */
+func (t *MulticastTransport) AllowSource(src net.IP) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.ssm {
+		return errNotSourceSpecific
+	}
+	for _, s := range t.sources {
+		if s.Equal(src) {
+			return nil
+		}
+	}
+
+	var err error
+
+	source := &net.UDPAddr{IP: src}
+
+	if t.conn6 != nil {
+		err = t.conn6.JoinSourceSpecificGroup(t.ifi, t.group, source)
+	} else {
+		err = t.conn4.JoinSourceSpecificGroup(t.ifi, t.group, source)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.sources = append(t.sources, src)
+	return nil
+}
+
+/**
+ * stops receiving packets from a source previously permitted by AllowSource.
+ */
+func (t *MulticastTransport) BlockSource(src net.IP) error {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.ssm {
+		return errNotSourceSpecific
+	}
+
+	for i, s := range t.sources {
+		if s.Equal(src) {
+			var err error
+
+			source := &net.UDPAddr{IP: src}
+
+			if t.conn6 != nil {
+				err = t.conn6.LeaveSourceSpecificGroup(t.ifi, t.group, source)
+			} else {
+				err = t.conn4.LeaveSourceSpecificGroup(t.ifi, t.group, source)
+			}
+
+			t.sources = append(t.sources[:i], t.sources[i+1:]...)
+			return err
+		}
+	}
+	return nil
+}
+
+/**
+ * returns the permitted sources of a source specific group, nil for an any
+ * source group.
+ */
+func (t *MulticastTransport) Sources() []net.IP {
+	t.Lock()
+	defer t.Unlock()
+	return append([]net.IP(nil), t.sources...)
+}
+
+/**
+ * leaves the group, or every permitted source of a source specific group.
+ */
+func (t *MulticastTransport) LeaveGroup() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.ssm {
+		if t.conn6 != nil {
+			return t.conn6.LeaveGroup(t.ifi, t.group)
+		}
+		return t.conn4.LeaveGroup(t.ifi, t.group)
+	}
+
+	var err error
+
+	for _, src := range t.sources {
+		source := &net.UDPAddr{IP: src}
+
+		var e error
+
+		if t.conn6 != nil {
+			e = t.conn6.LeaveSourceSpecificGroup(t.ifi, t.group, source)
+		} else {
+			e = t.conn4.LeaveSourceSpecificGroup(t.ifi, t.group, source)
+		}
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+/**
+ * joins the group again after LeaveGroup.
+ */
+func (t *MulticastTransport) JoinGroup() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if !t.ssm {
+		if t.conn6 != nil {
+			return t.conn6.JoinGroup(t.ifi, t.group)
+		}
+		return t.conn4.JoinGroup(t.ifi, t.group)
+	}
+
+	var err error
+
+	for _, src := range t.sources {
+		source := &net.UDPAddr{IP: src}
+
+		var e error
+
+		if t.conn6 != nil {
+			e = t.conn6.JoinSourceSpecificGroup(t.ifi, t.group, source)
+		} else {
+			e = t.conn4.JoinSourceSpecificGroup(t.ifi, t.group, source)
+		}
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (t *MulticastTransport) Close() error {
+	if t.conn6 != nil {
+		return t.conn6.Close()
+	}
+	return t.conn4.Close()
+}
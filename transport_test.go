@@ -0,0 +1,133 @@
+package lrmp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPv6MaxScope(t *testing.T) {
+	tests := []struct {
+		group string
+		ttl   int
+		zone  bool
+	}{
+		{"ff01::1", 0, true},
+		{"ff02::1", 1, true},
+		{"ff03::1", 15, false},
+		{"ff04::1", 15, false},
+		{"ff05::1", 15, false},
+		{"ff08::1", 63, false},
+		{"ff0e::1", 255, false},
+		{"ff35::1", 15, false},
+	}
+
+	for _, test := range tests {
+		ip := net.ParseIP(test.group)
+
+		if ttl := ipv6MaxScope(ip); ttl != test.ttl {
+			t.Errorf("%s: max scope %d, want %d", test.group, ttl, test.ttl)
+		}
+		if zone := ipv6NeedsZone(ip); zone != test.zone {
+			t.Errorf("%s: needs zone %v, want %v", test.group, zone, test.zone)
+		}
+	}
+}
+
+func TestSelectAddr(t *testing.T) {
+	var addrs []net.Addr
+	for _, a := range []string{"fe80::1/64", "10.0.0.1/8", "2001:db8::1/64", "fe80::2/64", "10.0.0.2/8"} {
+		ip, ipnet, _ := net.ParseCIDR(a)
+		ipnet.IP = ip
+		addrs = append(addrs, ipnet)
+	}
+
+	tests := []struct {
+		group string
+		addr  string
+	}{
+		{"225.0.0.1", "10.0.0.1"},
+		{"ff01::1", "fe80::1"},
+		{"ff02::1", "fe80::1"},
+		{"ff05::1", "2001:db8::1"},
+		{"ff0e::1", "2001:db8::1"},
+	}
+
+	for _, test := range tests {
+		addr, err := selectAddr(addrs, net.ParseIP(test.group))
+		if err != nil || !addr.Equal(net.ParseIP(test.addr)) {
+			t.Errorf("%s: address %v %v, want %s", test.group, addr, err, test.addr)
+		}
+	}
+
+	/* the other kind of address is used when there is no choice */
+
+	if addr, err := selectAddr(addrs[:1], net.ParseIP("ff0e::1")); err != nil || !addr.Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("global group: address %v %v with a link local address only", addr, err)
+	}
+	if addr, err := selectAddr(addrs[1:3], net.ParseIP("ff02::1")); err != nil || !addr.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("link local group: address %v %v with a global address only", addr, err)
+	}
+	if _, err := selectAddr(addrs[1:2], net.ParseIP("ff02::1")); err == nil {
+		t.Error("IPv6 group with an IPv4 address only")
+	}
+}
+
+func TestSourceSpecificRequiresSources(t *testing.T) {
+	if _, err := NewSourceSpecificTransport("232.1.1.1", 6000, "lo", nil); err == nil {
+		t.Fatal("source specific group joined without a source")
+	}
+
+	asm := &MulticastTransport{}
+
+	if err := asm.AllowSource(net.ParseIP("10.0.0.1")); err != errNotSourceSpecific {
+		t.Fatalf("AllowSource on an any source group: %v", err)
+	}
+	if err := asm.BlockSource(net.ParseIP("10.0.0.1")); err != errNotSourceSpecific {
+		t.Fatalf("BlockSource on an any source group: %v", err)
+	}
+	if asm.Sources() != nil {
+		t.Fatalf("sources %v of an any source group", asm.Sources())
+	}
+}
+
+/* needs an interface able to join a source specific group */
+func TestAllowAndBlockSource(t *testing.T) {
+	var tr *MulticastTransport
+
+	ifis, _ := net.Interfaces()
+	for _, ifi := range ifis {
+		if ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagUp == 0 {
+			continue
+		}
+		var err error
+		if tr, err = NewSourceSpecificTransport("232.1.1.1", 0, ifi.Name, []net.IP{net.ParseIP("10.0.0.1")}); err == nil {
+			break
+		}
+	}
+	if tr == nil {
+		t.Skip("no interface to join a source specific group")
+	}
+	defer tr.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := tr.AllowSource(net.ParseIP("10.0.0.2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sources := tr.Sources(); len(sources) != 2 || !sources[1].Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("sources %v after allowing a source twice", sources)
+	}
+
+	if err := tr.BlockSource(net.ParseIP("10.0.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	if sources := tr.Sources(); len(sources) != 1 || !sources[0].Equal(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("sources %v after blocking a source", sources)
+	}
+
+	/* unknown sources are ignored */
+
+	if err := tr.BlockSource(net.ParseIP("10.0.0.3")); err != nil || len(tr.Sources()) != 1 {
+		t.Fatalf("blocking an unknown source: %v, sources %v", err, tr.Sources())
+	}
+}
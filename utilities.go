@@ -58,11 +58,11 @@ const NtpOffsetMillis32 = int(NtpOffsetSeconds << 16)
  */
 func ntp32(millis int64) int {
 	millis += NtpOffsetMillis
-	return (int)((millis << 16) / 1000)
+	return int(uint32((millis << 16) / 1000))
 }
 
-func nowMillis() int64 {
-	return time.Now().UnixNano() / int64(time.Millisecond)
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
 }
 
 /**
@@ -71,6 +71,10 @@ func nowMillis() int64 {
  */
 func fixedPoint32ToMillis(fixed int) int {
 
+	/* differences of 32 bit NTP times wrap around */
+
+	fixed = int(int32(fixed))
+
 	/* fixed*1000/2^16 */
 
 	fixed -= (fixed >> 7) * 3
@@ -0,0 +1,361 @@
+/*
+Package vnet provides an in-process virtual multicast network. Any number of
+LRMP sessions in a single process can join a Group, each through its own
+Endpoint, and the group forwards packets between them according to the
+loss, delay and reordering characteristics configured per link.
+
+	g := vnet.NewGroup(1)
+	g.SetDefaultLink(vnet.LinkConfig{Loss: 0.2})
+
+	a, _ := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.1")), 0, *profile)
+	b, _ := lrmp.NewLrmpWithTransport(g.Join(net.ParseIP("10.0.0.2")), 0, *profile)
+*/
+package vnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robaho/lrmp"
+)
+
+/**
+ * LinkConfig describes the behaviour of the virtual link from one endpoint
+ * to another.
+ */
+type LinkConfig struct {
+	/* probability in [0,1] that a packet is dropped */
+	Loss float64
+	/* probability that a drop starts a burst, BurstLength packets are then dropped in a row */
+	BurstLoss   float64
+	BurstLength int
+	/*
+	 * fixed delay plus a random delay in [0,Jitter), a packet is never
+	 * delivered before one sent earlier on the same link
+	 */
+	Delay  time.Duration
+	Jitter time.Duration
+	/* probability that a packet is held back by ReorderDelay, so that later packets overtake it */
+	Reorder      float64
+	ReorderDelay time.Duration
+	/* probability that a packet is delivered twice */
+	Duplicate float64
+	/* packets are only delivered if sent with a TTL of at least Hops */
+	Hops int
+}
+
+const defaultReorderDelay = 10 * time.Millisecond
+const queueSize = 1024
+
+type link struct {
+	from string
+	to   string
+}
+
+/**
+ * Group is a virtual multicast group.
+ */
+type Group struct {
+	sync.Mutex
+	members map[string]*Endpoint
+	links   map[link]LinkConfig
+	bursts  map[link]int
+	/* delivery time of the last packet in order on a link */
+	lastDue     map[link]time.Time
+	defaultLink LinkConfig
+	random      *rand.Rand
+	clock       lrmp.Clock
+}
+
+/**
+ * creates a group, the seed makes the loss pattern reproducible.
+ */
+func NewGroup(seed int64) *Group {
+	g := Group{members: make(map[string]*Endpoint), links: make(map[link]LinkConfig), bursts: make(map[link]int), lastDue: make(map[link]time.Time)}
+	g.random = rand.New(rand.NewSource(seed))
+	g.clock = lrmp.SystemClock
+	return &g
+}
+
+/**
+ * sets the clock used to delay packets, this should be the clock of the
+ * sessions joining the group.
+ */
+func (g *Group) SetClock(clock lrmp.Clock) {
+	g.Lock()
+	defer g.Unlock()
+	g.clock = clock
+}
+
+/**
+ * sets the configuration used by links without a specific configuration.
+ */
+func (g *Group) SetDefaultLink(cfg LinkConfig) {
+	g.Lock()
+	defer g.Unlock()
+	g.defaultLink = cfg
+}
+
+/**
+ * sets the configuration of the link carrying packets from one address to
+ * another.
+ */
+func (g *Group) SetLink(from, to net.IP, cfg LinkConfig) {
+	g.Lock()
+	defer g.Unlock()
+	g.links[link{from.String(), to.String()}] = cfg
+}
+
+/**
+ * joins the group with the given address. The returned endpoint implements
+ * lrmp.Transport and leaves the group when closed.
+ */
+func (g *Group) Join(addr net.IP) *Endpoint {
+	e := Endpoint{group: g, addr: addr, queue: make(chan packet, queueSize), done: make(chan struct{})}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if old, ok := g.members[addr.String()]; ok {
+		old.leave()
+		old.dropDelayed()
+	}
+	g.members[addr.String()] = &e
+
+	return &e
+}
+
+func (g *Group) send(from *Endpoint, b []byte, ttl int) {
+	g.Lock()
+	defer g.Unlock()
+
+	now := g.clock.Now()
+
+	for _, to := range g.members {
+		if to == from || to.left {
+			continue
+		}
+
+		l := link{from.addr.String(), to.addr.String()}
+		cfg, ok := g.links[l]
+		if !ok {
+			cfg = g.defaultLink
+		}
+
+		if ttl < cfg.Hops || g.lose(l, cfg) {
+			to.dropped++
+			continue
+		}
+
+		copies := 1
+		if cfg.Duplicate > 0 && g.random.Float64() < cfg.Duplicate {
+			copies = 2
+		}
+
+		for i := 0; i < copies; i++ {
+			p := packet{data: append([]byte(nil), b...), src: from.addr}
+			due := now.Add(cfg.Delay)
+
+			if cfg.Jitter > 0 {
+				due = due.Add(time.Duration(g.random.Int63n(int64(cfg.Jitter))))
+			}
+
+			if cfg.Reorder > 0 && g.random.Float64() < cfg.Reorder {
+				if cfg.ReorderDelay > 0 {
+					due = due.Add(cfg.ReorderDelay)
+				} else {
+					due = due.Add(defaultReorderDelay)
+				}
+			} else {
+				if last := g.lastDue[l]; due.Before(last) {
+					due = last
+				}
+				g.lastDue[l] = due
+			}
+
+			g.schedule(to, p, due)
+		}
+	}
+}
+
+/**
+ * queues a packet for delivery at due, after the packets due at the same
+ * time. The packets due are delivered at once.
+ */
+func (g *Group) schedule(to *Endpoint, p packet, due time.Time) {
+	i := sort.Search(len(to.delayed), func(i int) bool { return to.delayed[i].due.After(due) })
+
+	to.delayed = append(to.delayed, delayed{})
+	copy(to.delayed[i+1:], to.delayed[i:])
+	to.delayed[i] = delayed{due: due, p: p}
+
+	if i == 0 {
+		g.deliverDue(to)
+	}
+}
+
+/**
+ * delivers the packets due to an endpoint and waits for the next one.
+ */
+func (g *Group) deliverDue(to *Endpoint) {
+	now := g.clock.Now()
+
+	for len(to.delayed) > 0 && !to.delayed[0].due.After(now) {
+		to.deliver(to.delayed[0].p)
+		to.delayed = to.delayed[1:]
+	}
+
+	if to.timer != nil {
+		to.timer.Stop()
+		to.timer = nil
+	}
+
+	if len(to.delayed) > 0 {
+		to.timer = g.clock.AfterFunc(to.delayed[0].due.Sub(now), func() {
+			g.Lock()
+			defer g.Unlock()
+			g.deliverDue(to)
+		})
+	}
+}
+
+func (g *Group) lose(l link, cfg LinkConfig) bool {
+	if g.bursts[l] > 0 {
+		g.bursts[l]--
+		return true
+	}
+	if cfg.Loss <= 0 || g.random.Float64() >= cfg.Loss {
+		return false
+	}
+	if cfg.BurstLength > 1 && g.random.Float64() < cfg.BurstLoss {
+		g.bursts[l] = cfg.BurstLength - 1
+	}
+	return true
+}
+
+func (g *Group) remove(e *Endpoint) {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.members[e.addr.String()] == e {
+		delete(g.members, e.addr.String())
+	}
+
+	e.dropDelayed()
+}
+
+type packet struct {
+	data []byte
+	src  net.IP
+}
+
+type delayed struct {
+	due time.Time
+	p   packet
+}
+
+/**
+ * Endpoint is the attachment of one host to a virtual group.
+ */
+type Endpoint struct {
+	group     *Group
+	addr      net.IP
+	queue     chan packet
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   int
+	left      bool
+	/* the packets in transit to the endpoint, ordered by delivery time */
+	delayed []delayed
+	timer   lrmp.Timer
+}
+
+var _ lrmp.MembershipTransport = (*Endpoint)(nil)
+
+var errClosed = errors.New("vnet: endpoint closed")
+
+func (e *Endpoint) deliver(p packet) {
+	select {
+	case <-e.done:
+	case e.queue <- p:
+	default:
+		/* receive buffer overflow */
+	}
+}
+
+func (e *Endpoint) ReadFrom(b []byte) (int, net.IP, error) {
+	select {
+	case <-e.done:
+		return 0, nil, errClosed
+	case p := <-e.queue:
+		return copy(b, p.data), p.src, nil
+	}
+}
+
+func (e *Endpoint) WriteTo(b []byte, ttl int) (int, error) {
+	select {
+	case <-e.done:
+		return 0, errClosed
+	default:
+	}
+
+	e.group.send(e, b, ttl)
+
+	return len(b), nil
+}
+
+func (e *Endpoint) LocalAddr() net.IP {
+	return e.addr
+}
+
+/**
+ * returns the number of packets destined to this endpoint that the links
+ * dropped.
+ */
+func (e *Endpoint) Dropped() int {
+	e.group.Lock()
+	defer e.group.Unlock()
+	return e.dropped
+}
+
+/**
+ * stops the delivery of the group packets to the endpoint, it can still send.
+ */
+func (e *Endpoint) LeaveGroup() error {
+	e.group.Lock()
+	defer e.group.Unlock()
+	e.left = true
+	return nil
+}
+
+func (e *Endpoint) JoinGroup() error {
+	e.group.Lock()
+	defer e.group.Unlock()
+	e.left = false
+	return nil
+}
+
+/**
+ * drops the packets in transit to the endpoint, the group must be locked.
+ */
+func (e *Endpoint) dropDelayed() {
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.delayed = nil
+}
+
+func (e *Endpoint) leave() {
+	e.closeOnce.Do(func() { close(e.done) })
+}
+
+func (e *Endpoint) Close() error {
+	e.leave()
+	e.group.remove(e)
+	return nil
+}
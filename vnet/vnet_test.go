@@ -0,0 +1,223 @@
+package vnet
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/robaho/lrmp"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestGroup(link LinkConfig) (*Group, *lrmp.VirtualClock, *Endpoint, *Endpoint) {
+	clock := lrmp.NewVirtualClock(epoch)
+
+	g := NewGroup(1)
+	g.SetClock(clock)
+	g.SetDefaultLink(link)
+
+	return g, clock, g.Join(net.ParseIP("10.0.0.1")), g.Join(net.ParseIP("10.0.0.2"))
+}
+
+/**
+ * sends count packets "0", "1"... advancing the clock by interval after each.
+ */
+func sendAll(t *testing.T, clock *lrmp.VirtualClock, from *Endpoint, count int, interval time.Duration) {
+	t.Helper()
+
+	for i := 0; i < count; i++ {
+		if _, err := from.WriteTo([]byte(strconv.Itoa(i)), 1); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(interval)
+	}
+}
+
+/**
+ * returns the packets queued to the endpoint as ints, without blocking.
+ */
+func received(t *testing.T, e *Endpoint) []int {
+	t.Helper()
+
+	var seqnos []int
+	for len(e.queue) > 0 {
+		p := <-e.queue
+		i, err := strconv.Atoi(string(p.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqnos = append(seqnos, i)
+	}
+	return seqnos
+}
+
+func isSorted(seqnos []int) bool {
+	for i := 1; i < len(seqnos); i++ {
+		if seqnos[i] < seqnos[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDelivery(t *testing.T) {
+	_, clock, a, b := newTestGroup(LinkConfig{})
+
+	sendAll(t, clock, a, 10, 0)
+
+	got := received(t, b)
+	if len(got) != 10 || !isSorted(got) {
+		t.Fatalf("received %v", got)
+	}
+	if len(received(t, a)) != 0 {
+		t.Fatal("sender received its own packets")
+	}
+
+	buff := make([]byte, 16)
+
+	a.WriteTo([]byte("hello"), 1)
+
+	n, src, err := b.ReadFrom(buff)
+	if err != nil || string(buff[:n]) != "hello" || !src.Equal(a.LocalAddr()) {
+		t.Fatalf("read %q from %v: %v", buff[:n], src, err)
+	}
+}
+
+func TestDelayAndJitterKeepOrder(t *testing.T) {
+	_, clock, a, b := newTestGroup(LinkConfig{Delay: 20 * time.Millisecond, Jitter: 15 * time.Millisecond})
+
+	sendAll(t, clock, a, 1, 19*time.Millisecond)
+
+	if len(received(t, b)) != 0 {
+		t.Fatal("delivered before the delay")
+	}
+
+	sendAll(t, clock, a, 100, time.Millisecond)
+	clock.Advance(40 * time.Millisecond)
+
+	got := received(t, b)
+	if !isSorted(got) {
+		t.Fatalf("reordered without Reorder: %v", got)
+	}
+	if clock.Pending() != 0 {
+		t.Fatalf("%d timers pending", clock.Pending())
+	}
+}
+
+func TestReorder(t *testing.T) {
+	_, clock, a, b := newTestGroup(LinkConfig{Reorder: 0.2, ReorderDelay: 5 * time.Millisecond})
+
+	sendAll(t, clock, a, 200, time.Millisecond)
+	clock.Advance(10 * time.Millisecond)
+
+	got := received(t, b)
+	if len(got) != 200 {
+		t.Fatalf("received %d packets", len(got))
+	}
+	if isSorted(got) {
+		t.Fatal("not reordered")
+	}
+}
+
+func TestLoss(t *testing.T) {
+	_, clock, a, b := newTestGroup(LinkConfig{Loss: 0.25})
+
+	sendAll(t, clock, a, 1000, 0)
+
+	got := received(t, b)
+	if len(got) < 650 || len(got) > 850 {
+		t.Fatalf("received %d of 1000 with 25%% loss", len(got))
+	}
+	if b.Dropped() != 1000-len(got) {
+		t.Fatalf("dropped %d, received %d", b.Dropped(), len(got))
+	}
+}
+
+func TestBurstLoss(t *testing.T) {
+	_, clock, a, b := newTestGroup(LinkConfig{Loss: 0.05, BurstLoss: 1, BurstLength: 4})
+
+	sendAll(t, clock, a, 1000, 0)
+
+	got := received(t, b)
+	if len(got) == 1000 {
+		t.Fatal("no loss")
+	}
+
+	/* the gaps are multiples of bursts, or bursts run together */
+
+	prev := -1
+	for _, i := range got {
+		if gap := i - prev - 1; gap > 0 && gap < 4 {
+			t.Fatalf("gap of %d before %d", gap, i)
+		}
+		prev = i
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	_, clock, a, b := newTestGroup(LinkConfig{Duplicate: 1})
+
+	sendAll(t, clock, a, 10, 0)
+
+	got := received(t, b)
+	if len(got) != 20 {
+		t.Fatalf("received %d packets", len(got))
+	}
+	for i := 0; i < 10; i++ {
+		if got[2*i] != i || got[2*i+1] != i {
+			t.Fatalf("received %v", got)
+		}
+	}
+}
+
+func TestLinkAndHops(t *testing.T) {
+	g, clock, a, b := newTestGroup(LinkConfig{})
+	c := g.Join(net.ParseIP("10.0.0.3"))
+
+	g.SetLink(a.LocalAddr(), c.LocalAddr(), LinkConfig{Hops: 2})
+
+	sendAll(t, clock, a, 1, 0)
+
+	if len(received(t, b)) != 1 || len(received(t, c)) != 0 {
+		t.Fatal("packet with ttl 1 crossed a 2 hops link")
+	}
+
+	a.WriteTo([]byte("1"), 2)
+
+	if len(received(t, c)) != 1 {
+		t.Fatal("packet with ttl 2 not delivered")
+	}
+}
+
+func TestLeaveAndClose(t *testing.T) {
+	g, clock, a, b := newTestGroup(LinkConfig{Delay: 10 * time.Millisecond})
+
+	b.LeaveGroup()
+	sendAll(t, clock, a, 1, 20*time.Millisecond)
+	if len(received(t, b)) != 0 {
+		t.Fatal("delivered after LeaveGroup")
+	}
+
+	b.JoinGroup()
+	sendAll(t, clock, a, 1, 20*time.Millisecond)
+	if len(received(t, b)) != 1 {
+		t.Fatal("not delivered after JoinGroup")
+	}
+
+	/* the packets in transit are dropped */
+
+	sendAll(t, clock, a, 1, 0)
+	b.Close()
+
+	if clock.Pending() != 0 {
+		t.Fatalf("%d timers pending after Close", clock.Pending())
+	}
+	if _, _, err := b.ReadFrom(make([]byte, 16)); err == nil {
+		t.Fatal("read on a closed endpoint")
+	}
+	if len(g.members) != 1 {
+		t.Fatalf("%d members after Close", len(g.members))
+	}
+}